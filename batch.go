@@ -0,0 +1,317 @@
+package event
+
+import (
+	"sync"
+
+	"github.com/the-anna-project/context"
+)
+
+// future is the Future implementation resolved by the flushes of the buffered
+// producer mode.
+type future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *future {
+	f := &future{
+		done: make(chan struct{}),
+		err:  nil,
+	}
+
+	return f
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future) Err() error {
+	<-f.done
+	return f.err
+}
+
+func (f *future) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// pendingCreate is a call to Service.Create buffered until the next flush.
+type pendingCreate struct {
+	event     Event
+	future    *future
+	namespace string
+	scope     scope
+}
+
+func (s *service) CreateAsync(ctx context.Context, event Event, labels ...string) Future {
+	f := newFuture()
+
+	if s.batchSize == 0 {
+		f.resolve(s.Create(ctx, event, labels...))
+		return f
+	}
+
+	err := s.bootError()
+	if err != nil {
+		f.resolve(maskAny(err))
+		return f
+	}
+
+	namespace := s.namespaceFromLabels(labels...)
+	if namespace == LabelWildcard {
+		f.resolve(maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search"))
+		return f
+	}
+
+	event, err = s.stamp(event)
+	if err != nil {
+		f.resolve(maskAny(err))
+		return f
+	}
+
+	err = s.validate(event, labels...)
+	if err != nil {
+		f.resolve(maskAny(err))
+		return f
+	}
+	err = s.checkCompaction(event)
+	if err != nil {
+		f.resolve(maskAny(err))
+		return f
+	}
+
+	sc, err := s.scope(ctx)
+	if err != nil {
+		f.resolve(maskAny(err))
+		return f
+	}
+
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	if s.batchClosed {
+		f.resolve(maskAnyf(invalidExecutionError, "service must not be shut down"))
+		return f
+	}
+
+	// The flushing worker is started here as well, so that buffered events are
+	// flushed even in case Service.Boot was never called.
+	s.startFlushing()
+
+	s.batch = append(s.batch, pendingCreate{event: event, future: f, namespace: namespace, scope: sc})
+	if len(s.batch) >= s.batchSize {
+		select {
+		case s.batchFull <- struct{}{}:
+		default:
+		}
+	}
+
+	return f
+}
+
+// flush writes all buffered events. The events are grouped by their namespace
+// and the groups are written concurrently, so that their round trips overlap.
+// The events of a group are published in the order they were buffered.
+func (s *service) flush() error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	s.batchMutex.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.batchMutex.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var groups [][]pendingCreate
+	indexes := map[string]int{}
+	for _, p := range batch {
+		key := p.scope.namespaceKey(p.namespace)
+		i, ok := indexes[key]
+		if !ok {
+			i = len(groups)
+			indexes[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], p)
+	}
+
+	var firstErr error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, s.batchConcurrency)
+
+	for _, g := range groups {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(g []pendingCreate) {
+			defer wg.Done()
+
+			err := s.publishGroup(g)
+			if err != nil {
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mutex.Unlock()
+			}
+
+			<-semaphore
+		}(g)
+	}
+
+	wg.Wait()
+
+	return maskAny(firstErr)
+}
+
+// flushPeriodically flushes the buffered events whenever the batch size is
+// reached or the batch interval passed, until the service is shut down.
+func (s *service) flushPeriodically() {
+	ticker := s.clock.NewTicker(s.batchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closer:
+			return
+		case <-ticker.C():
+		case <-s.batchFull:
+		}
+
+		s.instrumentor.Publisher.WrapFunc("Flush", s.flush)()
+	}
+}
+
+// publishGroup publishes the given buffered events of a single namespace and
+// resolves their futures. The namespace is registered once. Events carrying a
+// compaction key already carried by an earlier event of the group are
+// published after the earlier events, so that they are compacted against them.
+func (s *service) publishGroup(group []pendingCreate) error {
+	s.moveMutex.RLock()
+	defer s.moveMutex.RUnlock()
+
+	sc := group[0].scope
+	namespace := group[0].namespace
+	shard := s.shardFor(sc, namespace)
+
+	err := s.register(shard, sc, namespace)
+	if err != nil {
+		for _, p := range group {
+			p.future.resolve(maskAny(err))
+		}
+		return maskAny(err)
+	}
+
+	var firstErr error
+	var segment []pendingCreate
+	keys := map[string]bool{}
+	for _, p := range group {
+		key := p.event.Headers()[HeaderCompactionKey]
+		if key != "" && keys[key] {
+			err := s.publishSegment(shard, sc, namespace, segment)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			segment = nil
+			keys = map[string]bool{}
+		}
+		if key != "" {
+			keys[key] = true
+		}
+		segment = append(segment, p)
+	}
+	err = s.publishSegment(shard, sc, namespace, segment)
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// publishSegment publishes the given buffered events of a single namespace,
+// which carry distinct compaction keys, and resolves their futures. The event
+// payloads are stored before the event IDs are pushed to the namespaced queue
+// in order, within a single round trip for storages implementing MultiPusher.
+func (s *service) publishSegment(shard Storage, sc scope, namespace string, segment []pendingCreate) error {
+	var firstErr error
+	fail := func(p pendingCreate, err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+		p.future.resolve(err)
+	}
+
+	var eventIDs []string
+	var pending []pendingCreate
+	for _, p := range segment {
+		replaced, err := s.compact(shard, sc, namespace, p.event)
+		if err != nil {
+			fail(p, maskAny(err))
+			continue
+		}
+		if replaced {
+			p.future.resolve(nil)
+			continue
+		}
+
+		b, err := s.marshal(sc, namespace, p.event)
+		if err != nil {
+			fail(p, maskAny(s.uncompact(shard, sc, p.event.ID(), err)))
+			continue
+		}
+		err = s.checkQuota(shard, sc, namespace, b)
+		if err != nil {
+			fail(p, maskAny(s.uncompact(shard, sc, p.event.ID(), err)))
+			continue
+		}
+
+		// Store the event payload.
+		err = s.store(shard, sc, p.event.ID(), b)
+		if err != nil {
+			fail(p, maskAny(s.uncompact(shard, sc, p.event.ID(), s.release(sc, len(b), err))))
+			continue
+		}
+
+		eventIDs = append(eventIDs, p.event.ID())
+		pending = append(pending, p)
+	}
+
+	if len(pending) == 0 {
+		return firstErr
+	}
+
+	// Publish the event IDs in their namespaced queue.
+	err := pushMany(shard, sc.namespaceKey(namespace), eventIDs)
+	if err != nil {
+		for i, p := range pending {
+			fail(p, maskAny(s.unstore(shard, sc, eventIDs[i], err)))
+		}
+		return firstErr
+	}
+
+	for _, p := range pending {
+		p.future.resolve(nil)
+	}
+
+	return firstErr
+}
+
+// shutdownBatch refuses upcoming buffered events and flushes the pending ones.
+func (s *service) shutdownBatch() {
+	s.batchMutex.Lock()
+	s.batchClosed = true
+	s.batchMutex.Unlock()
+
+	s.instrumentor.Publisher.WrapFunc("Flush", s.flush)()
+}
+
+// startFlushing starts the flushing worker unless it already runs.
+func (s *service) startFlushing() {
+	s.flushOnce.Do(func() {
+		go s.flushPeriodically()
+	})
+}
@@ -0,0 +1,203 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newBatchTestService(t *testing.T, s *memoryStorage, bulk bool) *service {
+	f := func(config *ServiceConfig) {
+		config.BatchSize = 100
+		config.BatchInterval = time.Hour
+	}
+	if bulk {
+		return newTestService(t, bulkStorage{s}, f)
+	}
+
+	return newTestService(t, s, f)
+}
+
+// createInterleaved buffers events of two namespaces in alternating order and
+// flushes them.
+func createInterleaved(t *testing.T, s *service) {
+	var futures []Future
+	for i, labels := range [][]string{{"a"}, {"b"}, {"a"}, {"b"}, {"a"}} {
+		e := newTestEvent(t, string(rune('1'+i)), "payload")
+		futures = append(futures, s.CreateAsync(nil, e, labels...))
+	}
+
+	err := s.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range futures {
+		err := f.Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_Service_Flush_Order(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		m := newMemoryStorage()
+		s := newBatchTestService(t, m, bulk)
+
+		createInterleaved(t, s)
+
+		sc, err := s.scope(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The head of the list holds the newest event, so the events buffered
+		// first are consumed first.
+		expected := map[string][]string{
+			"a": {"5", "3", "1"},
+			"b": {"4", "2"},
+		}
+		for namespace, eventIDs := range expected {
+			l := m.lists[sc.namespaceKey(namespace)]
+			if !reflect.DeepEqual(l, eventIDs) {
+				t.Fatalf("bulk %t: expected queue %v of namespace %s, got %v", bulk, eventIDs, namespace, l)
+			}
+		}
+	}
+}
+
+func Test_Service_Flush_SingleRoundTrip(t *testing.T) {
+	var roundTrips []int
+	for _, bulk := range []bool{false, true} {
+		m := newMemoryStorage()
+		s := newBatchTestService(t, m, bulk)
+
+		start := m.roundTrips()
+		createInterleaved(t, s)
+		roundTrips = append(roundTrips, m.roundTrips()-start)
+	}
+
+	// Pushing the 3 event IDs of namespace a and the 2 event IDs of namespace b
+	// takes 2 instead of 5 round trips.
+	if roundTrips[0]-roundTrips[1] != 3 {
+		t.Fatalf("expected 3 round trips less, got %v", roundTrips)
+	}
+}
+
+func Test_Service_CreateAsync_WithoutBoot(t *testing.T) {
+	s := newUnbootedTestService(t, newMemoryStorage(), func(config *ServiceConfig) {
+		config.BatchSize = 1
+		config.BatchInterval = time.Hour
+	})
+
+	select {
+	case <-s.CreateAsync(nil, newTestEvent(t, "", "payload"), "a").Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected buffered event to be flushed without boot")
+	}
+}
+
+// orderStorage is a memoryStorage asserting that event IDs are pushed to a
+// queue only after their payloads were stored.
+type orderStorage struct {
+	*memoryStorage
+
+	sc scope
+	t  *testing.T
+}
+
+func (m *orderStorage) PushToList(key string, element string) error {
+	if key == m.sc.namespaceKey("a") {
+		ok, err := m.Exists(m.sc.eventKey(element))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			m.t.Errorf("expected payload of event %s to be stored before its ID is pushed", element)
+		}
+	}
+
+	return m.memoryStorage.PushToList(key, element)
+}
+
+func Test_Service_Flush_PayloadBeforeID(t *testing.T) {
+	for _, batchSize := range []int{0, 100} {
+		m := &orderStorage{memoryStorage: newMemoryStorage(), t: t}
+		s := newTestService(t, m, func(config *ServiceConfig) {
+			config.BatchSize = batchSize
+			config.BatchInterval = time.Hour
+		})
+		m.sc = s.scopeOf("")
+
+		f := s.CreateAsync(nil, newTestEvent(t, "1", "payload"), "a")
+		err := s.flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_Service_Flush_Compaction(t *testing.T) {
+	testCases := []struct {
+		Mode     string
+		Expected []string
+	}{
+		{Mode: CompactionReplace, Expected: []string{"1:new", "2:other"}},
+		{Mode: CompactionDrop, Expected: []string{"2:other", "4:new"}},
+	}
+
+	for _, tc := range testCases {
+		m := newMemoryStorage()
+		s := newTestService(t, m, func(config *ServiceConfig) {
+			config.BatchSize = 100
+			config.BatchInterval = time.Hour
+			config.CompactionMode = tc.Mode
+		})
+
+		// Events of the same compaction key are compacted within a flush as well
+		// as across flushes.
+		var futures []Future
+		for _, e := range []Event{
+			newCompactionTestEvent(t, "1", "old", "k"),
+			newCompactionTestEvent(t, "2", "other", ""),
+			newCompactionTestEvent(t, "3", "newer", "k"),
+		} {
+			futures = append(futures, s.CreateAsync(nil, e, "a"))
+		}
+		err := s.flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, s.CreateAsync(nil, newCompactionTestEvent(t, "4", "new", "k"), "a"))
+		err = s.flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range futures {
+			err := f.Err()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var consumed []string
+		for {
+			ok, err := s.ExistsAny(nil, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			e := consume(t, s)
+			consumed = append(consumed, e.ID()+":"+e.Payload())
+		}
+		if !reflect.DeepEqual(consumed, tc.Expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.Mode, tc.Expected, consumed)
+		}
+	}
+}
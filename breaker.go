@@ -0,0 +1,343 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// BreakerClosed represents the state of a circuit breaker passing all
+	// storage operations through.
+	BreakerClosed = "closed"
+	// BreakerHalfOpen represents the state of a circuit breaker passing a limited
+	// number of probing storage operations through after it was open.
+	BreakerHalfOpen = "half-open"
+	// BreakerOpen represents the state of a circuit breaker failing all storage
+	// operations fast.
+	BreakerOpen = "open"
+)
+
+// breaker is a storage guarding another storage with a circuit breaker. After
+// the configured number of consecutive failures the breaker opens and fails
+// all operations fast. Once the open duration passed, the breaker lets the
+// configured number of probes through. In case all of them succeed, the
+// breaker closes again. Otherwise it opens again.
+//
+// Popping from a list blocks until an element is available, which is why pops
+// never occupy one of the probe slots. While the breaker is half-open, they
+// pass through and a failed pop opens the breaker again.
+type breaker struct {
+	// Dependencies.
+	clock   Clock
+	storage Storage
+
+	// Internals.
+	changes     [][2]string
+	failures    int
+	generation  int
+	inFlight    int
+	mutex       sync.Mutex
+	notifyMutex sync.Mutex
+	openedAt    time.Time
+	state       string
+	successes   int
+
+	// Settings.
+	changed      func(from, to string)
+	openDuration time.Duration
+	probes       int
+	threshold    int
+}
+
+func newBreaker(s Storage, clock Clock, threshold int, openDuration time.Duration, probes int, changed func(from, to string)) *breaker {
+	b := &breaker{
+		// Dependencies.
+		clock:   clock,
+		storage: s,
+
+		// Internals.
+		changes:     nil,
+		failures:    0,
+		generation:  0,
+		inFlight:    0,
+		mutex:       sync.Mutex{},
+		notifyMutex: sync.Mutex{},
+		openedAt:    time.Time{},
+		state:       BreakerClosed,
+		successes:   0,
+
+		// Settings.
+		changed:      changed,
+		openDuration: openDuration,
+		probes:       probes,
+		threshold:    threshold,
+	}
+
+	return b
+}
+
+func (b *breaker) AppendManyToList(key string, elements []string) error {
+	return b.do(func() error { return appendMany(b.storage, key, elements) })
+}
+
+func (b *breaker) EvictFromList(key string, n int) ([]string, error) {
+	var elements []string
+	err := b.do(func() error {
+		var err error
+		elements, err = evictFromList(b.storage, key, n)
+		return err
+	})
+	return elements, err
+}
+
+func (b *breaker) Exists(key string) (bool, error) {
+	var ok bool
+	err := b.do(func() error {
+		var err error
+		ok, err = b.storage.Exists(key)
+		return err
+	})
+	return ok, err
+}
+
+func (b *breaker) Get(key string) (string, error) {
+	var value string
+	err := b.do(func() error {
+		var err error
+		value, err = b.storage.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (b *breaker) GetAllFromList(key string) ([]string, error) {
+	var values []string
+	err := b.do(func() error {
+		var err error
+		values, err = b.storage.GetAllFromList(key)
+		return err
+	})
+	return values, err
+}
+
+func (b *breaker) GetAllFromSet(key string) ([]string, error) {
+	var values []string
+	err := b.do(func() error {
+		var err error
+		values, err = b.storage.GetAllFromSet(key)
+		return err
+	})
+	return values, err
+}
+
+func (b *breaker) GetMany(keys []string) ([]string, error) {
+	var values []string
+	err := b.do(func() error {
+		var err error
+		values, err = getMany(b.storage, keys)
+		return err
+	})
+	return values, err
+}
+
+func (b *breaker) GetRandomFromSet(key string) (string, error) {
+	var value string
+	err := b.do(func() error {
+		var err error
+		value, err = b.storage.GetRandomFromSet(key)
+		return err
+	})
+	return value, err
+}
+
+func (b *breaker) IncrementBy(key string, delta int) (int, error) {
+	var n int
+	err := b.do(func() error {
+		var err error
+		n, err = incrementBy(b.storage, key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (b *breaker) LengthOfList(key string) (int, error) {
+	var n int
+	err := b.do(func() error {
+		var err error
+		n, err = listLength(b.storage, key)
+		return err
+	})
+	return n, err
+}
+
+func (b *breaker) PopFromList(key string) (string, error) {
+	var value string
+	err := b.doBlocking(func() error {
+		var err error
+		value, err = b.storage.PopFromList(key)
+		return err
+	})
+	return value, err
+}
+
+func (b *breaker) PushManyToList(key string, elements []string) error {
+	return b.do(func() error { return pushMany(b.storage, key, elements) })
+}
+
+func (b *breaker) PushToList(key string, element string) error {
+	return b.do(func() error { return b.storage.PushToList(key, element) })
+}
+
+func (b *breaker) PushToSet(key string, element string) error {
+	return b.do(func() error { return b.storage.PushToSet(key, element) })
+}
+
+func (b *breaker) Remove(key string) error {
+	return b.do(func() error { return b.storage.Remove(key) })
+}
+
+func (b *breaker) RemoveFromSet(key string, element string) error {
+	return b.do(func() error { return b.storage.RemoveFromSet(key, element) })
+}
+
+func (b *breaker) Set(key, value string) error {
+	return b.do(func() error { return b.storage.Set(key, value) })
+}
+
+func (b *breaker) TrimEndOfList(key string, maxElements int) error {
+	return b.do(func() error { return b.storage.TrimEndOfList(key, maxElements) })
+}
+
+// allow decides whether an operation may be passed through to the storage.
+// Unless the operation blocks, it occupies one of the probe slots while the
+// breaker is half-open. allow returns the generation of the breaker's state the
+// operation started in, and whether the operation is a probe.
+func (b *breaker) allow(blocking bool) (int, bool, error) {
+	defer b.notify()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.clock.Now().Sub(b.openedAt) < b.openDuration {
+			return 0, false, maskAnyf(unavailableError, "circuit breaker is open")
+		}
+		b.transition(BreakerHalfOpen)
+	}
+
+	if b.state == BreakerHalfOpen && !blocking {
+		if b.inFlight >= b.probes {
+			return 0, false, maskAnyf(unavailableError, "circuit breaker is half-open")
+		}
+		b.inFlight++
+
+		return b.generation, true, nil
+	}
+
+	return b.generation, false, nil
+}
+
+// do passes the given operation through to the storage in case the breaker
+// allows it, and records its outcome.
+func (b *breaker) do(operation func() error) error {
+	return b.pass(false, operation)
+}
+
+// doBlocking is like do for operations that may block indefinitely.
+func (b *breaker) doBlocking(operation func() error) error {
+	return b.pass(true, operation)
+}
+
+// notify publishes the state changes recorded by transition. It must be called
+// without holding the breaker's mutex, so that the change handler may take its
+// time. Changes are published in the order they happened.
+func (b *breaker) notify() {
+	b.notifyMutex.Lock()
+	defer b.notifyMutex.Unlock()
+
+	b.mutex.Lock()
+	changes := b.changes
+	b.changes = nil
+	b.mutex.Unlock()
+
+	if b.changed == nil {
+		return
+	}
+	for _, c := range changes {
+		b.changed(c[0], c[1])
+	}
+}
+
+func (b *breaker) pass(blocking bool, operation func() error) error {
+	generation, probe, err := b.allow(blocking)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = operation()
+	b.record(generation, probe, err)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// record updates the breaker's state with the outcome of an operation. Not
+// found errors are regular results of the storage and thus do not count as
+// failures. Outcomes of operations started before the breaker's last state
+// change are ignored, because they do not tell anything about the current
+// state.
+func (b *breaker) record(generation int, probe bool, err error) {
+	defer b.notify()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	failed := err != nil && !isNotFound(err)
+
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			b.transition(BreakerOpen)
+			return
+		}
+		if !probe {
+			return
+		}
+		b.inFlight--
+		b.successes++
+		if b.successes >= b.probes {
+			b.transition(BreakerClosed)
+		}
+	}
+}
+
+// transition moves the breaker into the given state and starts a new
+// generation. The caller must hold the breaker's mutex. The change is published
+// by notify once the mutex is released.
+func (b *breaker) transition(state string) {
+	b.changes = append(b.changes, [2]string{b.state, state})
+
+	b.failures = 0
+	b.generation++
+	b.inFlight = 0
+	b.state = state
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = b.clock.Now()
+	}
+}
@@ -0,0 +1,188 @@
+package event
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedStorage is a memoryStorage whose operations fail on demand. Pops wait
+// for the pop gate before they return.
+type gatedStorage struct {
+	*memoryStorage
+
+	entered chan struct{}
+	fail    bool
+	mutex   sync.Mutex
+	popGate chan struct{}
+}
+
+func (g *gatedStorage) failing() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.fail
+}
+
+func (g *gatedStorage) setFail(fail bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.fail = fail
+}
+
+func (g *gatedStorage) Exists(key string) (bool, error) {
+	if g.failing() {
+		return false, maskAnyf(unavailableError, "storage failed")
+	}
+	return g.memoryStorage.Exists(key)
+}
+
+func (g *gatedStorage) PopFromList(key string) (string, error) {
+	close(g.entered)
+	<-g.popGate
+	return g.memoryStorage.PopFromList(key)
+}
+
+func (b *breaker) currentState() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+func newTestBreaker(probes int, changed func(from, to string)) (*breaker, *gatedStorage, FakeClock) {
+	g := &gatedStorage{
+		entered:       make(chan struct{}),
+		memoryStorage: newMemoryStorage(),
+		popGate:       make(chan struct{}),
+	}
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	return newBreaker(g, clock, 2, time.Second, probes, changed), g, clock
+}
+
+// openBreaker makes the breaker open and lets the open duration pass.
+func openBreaker(b *breaker, g *gatedStorage, clock FakeClock) {
+	g.setFail(true)
+	for i := 0; i < 2; i++ {
+		b.Exists("k")
+	}
+	g.setFail(false)
+	clock.Advance(time.Second)
+}
+
+func Test_Breaker_Lifecycle(t *testing.T) {
+	b, g, clock := newTestBreaker(2, nil)
+
+	openBreaker(b, g, clock)
+
+	for i := 0; i < 2; i++ {
+		_, err := b.Exists("k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && b.currentState() != BreakerHalfOpen {
+			t.Fatalf("expected breaker to be half-open, got %s", b.currentState())
+		}
+	}
+
+	if b.currentState() != BreakerClosed {
+		t.Fatalf("expected breaker to be closed, got %s", b.currentState())
+	}
+}
+
+func Test_Breaker_StaleOperation(t *testing.T) {
+	b, g, clock := newTestBreaker(1, nil)
+
+	// An operation starts while the breaker is closed and finishes after the
+	// breaker became half-open and handed out its only probe slot.
+	generation, probe, err := b.allow(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openBreaker(b, g, clock)
+	probeGeneration, isProbe, err := b.allow(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isProbe {
+		t.Fatal("expected probe")
+	}
+
+	// The stale outcome must neither free the probe slot nor count as probe.
+	b.record(generation, probe, nil)
+	if b.currentState() != BreakerHalfOpen {
+		t.Fatalf("expected breaker to be half-open, got %s", b.currentState())
+	}
+	_, err = b.Exists("k")
+	if !IsUnavailable(err) {
+		t.Fatalf("expected unavailable error, got %#v", err)
+	}
+
+	b.record(probeGeneration, isProbe, nil)
+	if b.currentState() != BreakerClosed {
+		t.Fatalf("expected breaker to be closed, got %s", b.currentState())
+	}
+}
+
+func Test_Breaker_BlockingPop(t *testing.T) {
+	b, g, clock := newTestBreaker(1, nil)
+
+	openBreaker(b, g, clock)
+
+	done := make(chan struct{})
+	go func() {
+		b.PopFromList("k")
+		close(done)
+	}()
+	<-g.entered
+
+	// The pop blocks without occupying the probe slot, so the probe passes.
+	if b.currentState() != BreakerHalfOpen {
+		t.Fatalf("expected breaker to be half-open, got %s", b.currentState())
+	}
+	_, err := b.Exists("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.currentState() != BreakerClosed {
+		t.Fatalf("expected breaker to be closed, got %s", b.currentState())
+	}
+
+	close(g.popGate)
+	<-done
+}
+
+func Test_Breaker_ChangedWithoutLock(t *testing.T) {
+	var b *breaker
+	var changes []string
+	b, g, clock := newTestBreaker(1, func(from, to string) {
+		// Handlers may inspect the breaker, which deadlocks in case the mutex is
+		// still held.
+		changes = append(changes, from+">"+to+":"+b.currentState())
+	})
+
+	done := make(chan struct{})
+	go func() {
+		openBreaker(b, g, clock)
+		b.Exists("k")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected change handler to be called without holding the mutex")
+	}
+
+	expected := []string{
+		BreakerClosed + ">" + BreakerOpen + ":" + BreakerOpen,
+		BreakerOpen + ">" + BreakerHalfOpen + ":" + BreakerHalfOpen,
+		BreakerHalfOpen + ">" + BreakerClosed + ":" + BreakerClosed,
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+}
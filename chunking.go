@@ -0,0 +1,314 @@
+package event
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// chunkTag frames the manifest stored in place of an event whose stored
+	// representation was split into chunks.
+	chunkTag = "chunked"
+)
+
+// chunkManifest describes the chunks of a stored event. The chunks are stored
+// under the chunk keys of the event, numbered from 0.
+type chunkManifest struct {
+	Chunks int `json:"chunks"`
+	// Digest is the hex encoded SHA-256 digest of the reassembled chunks.
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+}
+
+// manifest returns the chunk manifest the given stored value consists of. In
+// case the value is not a manifest, ok is false.
+func manifest(rawEvent string) (chunkManifest, bool, error) {
+	tag, body, ok := unframe([]byte(rawEvent))
+	if !ok || tag != chunkTag {
+		return chunkManifest{}, false, nil
+	}
+
+	var m chunkManifest
+	err := json.Unmarshal(body, &m)
+	if err != nil {
+		return chunkManifest{}, false, maskAny(err)
+	}
+
+	return m, true, nil
+}
+
+// store stores the given stored representation of an event. Representations
+// exceeding the chunk size are split into chunks, which are written before the
+// manifest replacing the representation, so that consumers never read a
+// manifest without chunks.
+func (s *service) store(shard Storage, sc scope, eventID string, b []byte) error {
+	if s.chunkSize == 0 || len(b) <= s.chunkSize {
+		err := shard.Set(sc.eventKey(eventID), string(b))
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	digest := sha256.Sum256(b)
+	m := chunkManifest{
+		Chunks: 0,
+		Digest: hex.EncodeToString(digest[:]),
+		Size:   len(b),
+	}
+	for i := 0; i < len(b); i += s.chunkSize {
+		end := i + s.chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		err := shard.Set(sc.chunkKey(eventID, m.Chunks), string(b[i:end]))
+		if err != nil {
+			return maskAny(err)
+		}
+		m.Chunks++
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = shard.Set(sc.eventKey(eventID), string(frame(chunkTag, body)))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// load returns the stored representation of the event stored under the given
+// event ID. The given stored value is returned as it is in case it is not a
+// chunk manifest. Otherwise the chunks are reassembled and verified against the
+// digest of the manifest.
+func (s *service) load(shard Storage, sc scope, eventID string, rawEvent string) (string, error) {
+	m, ok, err := manifest(rawEvent)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	if !ok {
+		return rawEvent, nil
+	}
+
+	var keys []string
+	for i := 0; i < m.Chunks; i++ {
+		keys = append(keys, sc.chunkKey(eventID, i))
+	}
+	chunks, err := getMany(shard, keys)
+	if err != nil {
+		return "", maskAny(err)
+	}
+
+	var buf bytes.Buffer
+	for _, c := range chunks {
+		buf.WriteString(c)
+	}
+	digest := sha256.Sum256(buf.Bytes())
+	if buf.Len() != m.Size || hex.EncodeToString(digest[:]) != m.Digest {
+		return "", maskAnyf(invalidExecutionError, "chunks of event %s must match digest", eventID)
+	}
+
+	return buf.String(), nil
+}
+
+// overwrite replaces the stored representation of the event stored under the
+// given event ID. Chunks of the replaced representation that the new one does
+// not reuse are removed afterwards.
+func (s *service) overwrite(shard Storage, sc scope, eventID string, b []byte) error {
+	old, err := s.storedChunks(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+	size, err := s.storedSize(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = s.store(shard, sc, eventID, b)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = s.account(sc, len(b)-size)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	current, err := s.storedChunks(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+	for i := current; i < old; i++ {
+		err := shard.Remove(sc.chunkKey(eventID, i))
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// remove removes the stored representation of the event stored under the given
+// event ID together with its chunks. The event is released from its compaction
+// key, because it is not queued anymore.
+func (s *service) remove(shard Storage, sc scope, eventID string) error {
+	err := s.releaseCompacted(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	chunks, err := s.storedChunks(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+	size, err := s.storedSize(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = shard.Remove(sc.eventKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+	if len(s.retentionPolicies) != 0 {
+		err := shard.Remove(sc.firstSeenKey(eventID))
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+	err = s.account(sc, -size)
+	if err != nil {
+		return maskAny(err)
+	}
+	for i := 0; i < chunks; i++ {
+		err := shard.Remove(sc.chunkKey(eventID, i))
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// unstore removes the stored representation of the event stored under the
+// given event ID, whose ID could not be published because of the given error.
+// The given error is returned unless removing fails.
+func (s *service) unstore(shard Storage, sc scope, eventID string, err error) error {
+	rerr := s.remove(shard, sc, eventID)
+	if rerr != nil {
+		return maskAny(rerr)
+	}
+
+	return maskAny(err)
+}
+
+// storedChunks returns the number of chunks stored for the event stored under
+// the given event ID. Looking up the chunks costs additional storage round
+// trips, which is why chunks are only looked up while chunking is configured.
+func (s *service) storedChunks(shard Storage, sc scope, eventID string) (int, error) {
+	if s.chunkSize == 0 {
+		return 0, nil
+	}
+
+	ok, err := shard.Exists(sc.eventKey(eventID))
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if !ok {
+		return 0, nil
+	}
+	rawEvent, err := shard.Get(sc.eventKey(eventID))
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	m, _, err := manifest(rawEvent)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+
+	return m.Chunks, nil
+}
+
+// storedEvents fetches the stored values of the events with the given IDs and
+// returns them together with their IDs. Events removed while they are fetched,
+// e.g. by a consumer, Service.Limit or retention, are skipped.
+func (s *service) storedEvents(shard Storage, sc scope, eventIDs []string) ([]string, []string, error) {
+	var keys []string
+	for _, eventID := range eventIDs {
+		keys = append(keys, sc.eventKey(eventID))
+	}
+	rawEvents, err := getMany(shard, keys)
+	if err == nil {
+		return eventIDs, rawEvents, nil
+	} else if !isNotFound(err) {
+		return nil, nil, maskAny(err)
+	}
+
+	// Some event was removed in the meantime. The remaining events are fetched
+	// one by one.
+	var ids []string
+	rawEvents = nil
+	for _, eventID := range eventIDs {
+		ok, err := shard.Exists(sc.eventKey(eventID))
+		if err != nil {
+			return nil, nil, maskAny(err)
+		}
+		if !ok {
+			continue
+		}
+		rawEvent, err := shard.Get(sc.eventKey(eventID))
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, maskAny(err)
+		}
+
+		ids = append(ids, eventID)
+		rawEvents = append(rawEvents, rawEvent)
+	}
+
+	return ids, rawEvents, nil
+}
+
+// storedSize returns the size of the stored representation of the event stored
+// under the given event ID, which is the size accounted for by the quota of the
+// scope's tenant. Looking up the size costs additional storage round trips,
+// which is why it is only looked up while the stored bytes are limited.
+func (s *service) storedSize(shard Storage, sc scope, eventID string) (int, error) {
+	if s.quota(sc).MaxStoredBytes == 0 {
+		return 0, nil
+	}
+
+	ok, err := shard.Exists(sc.eventKey(eventID))
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if !ok {
+		return 0, nil
+	}
+	rawEvent, err := shard.Get(sc.eventKey(eventID))
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	m, ok, err := manifest(rawEvent)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if ok {
+		return m.Size, nil
+	}
+
+	return len(rawEvent), nil
+}
+
+// redis string
+// holding a chunk of an event
+func (c scope) chunkKey(eventID string, i int) string {
+	return fmt.Sprintf("%s:chunk:%d", c.eventKey(eventID), i)
+}
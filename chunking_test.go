@@ -0,0 +1,115 @@
+package event
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Service_Limit_WithoutChunking(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, nil)
+
+	create(t, s,
+		newTestEvent(t, "1", "payload"),
+		newTestEvent(t, "2", "payload"),
+	)
+
+	// Without chunking the list is trimmed within a single round trip.
+	start := m.roundTrips()
+	err := s.Limit(nil, 1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.roundTrips()-start != 1 {
+		t.Fatalf("expected 1 round trip, got %d", m.roundTrips()-start)
+	}
+}
+
+func Test_Service_Limit_WithChunking(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, bulkStorage{m}, func(config *ServiceConfig) {
+		config.ChunkSize = 16
+	})
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create(t, s,
+		newTestEvent(t, "1", strings.Repeat("a", 64)),
+		newTestEvent(t, "2", strings.Repeat("b", 64)),
+	)
+
+	err = s.Limit(nil, 1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m.lists[sc.namespaceKey("a")], []string{"2"}) {
+		t.Fatalf("expected queue [2], got %v", m.lists[sc.namespaceKey("a")])
+	}
+	for key := range m.strings {
+		if strings.HasPrefix(key, sc.eventKey("1")) {
+			t.Fatalf("expected key %s of the trimmed event to be removed", key)
+		}
+	}
+}
+
+// vanishingStorage is a bulkStorage removing the given event along with its
+// chunks right before the given keys are fetched.
+type vanishingStorage struct {
+	bulkStorage
+	key    string
+	remove func()
+}
+
+func (v vanishingStorage) GetMany(keys []string) ([]string, error) {
+	for _, k := range keys {
+		if k == v.key {
+			v.remove()
+		}
+	}
+
+	return v.bulkStorage.GetMany(keys)
+}
+
+func Test_Service_SearchAll_Vanishing(t *testing.T) {
+	for _, chunk := range []bool{false, true} {
+		m := newMemoryStorage()
+		v := vanishingStorage{bulkStorage: bulkStorage{m}}
+		s := newTestService(t, &v, func(config *ServiceConfig) {
+			config.ChunkSize = 16
+		})
+		sc, err := s.scope(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		create(t, s,
+			newTestEvent(t, "1", strings.Repeat("a", 64)),
+			newTestEvent(t, "2", strings.Repeat("b", 64)),
+		)
+
+		// Event 1 is deleted while its payload or its chunks are read.
+		v.key = sc.eventKey("1")
+		if chunk {
+			v.key = sc.chunkKey("1", 0)
+		}
+		v.remove = func() {
+			for key := range m.strings {
+				if strings.HasPrefix(key, sc.eventKey("1")) {
+					delete(m.strings, key)
+				}
+			}
+		}
+
+		events, err := s.SearchAll(nil, "a")
+		if err != nil {
+			t.Fatalf("chunk %t: %s", chunk, err)
+		}
+		if len(events) != 1 || events[0].ID() != "2" {
+			t.Fatalf("chunk %t: expected event 2, got %v", chunk, events)
+		}
+	}
+}
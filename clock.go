@@ -0,0 +1,235 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/the-anna-project/id"
+)
+
+var (
+	sharedIDGenerator     IDGenerator
+	sharedIDGeneratorErr  error
+	sharedIDGeneratorOnce sync.Once
+)
+
+// NewClock creates a new clock telling the system time.
+func NewClock() Clock {
+	return &clock{}
+}
+
+// NewFakeClock creates a new fake clock standing still at the given time until
+// it is advanced or set.
+func NewFakeClock(now time.Time) FakeClock {
+	newClock := &fakeClock{
+		// Internals.
+		mutex:   sync.Mutex{},
+		now:     now,
+		tickers: nil,
+	}
+
+	return newClock
+}
+
+// NewFakeIDGenerator creates a new fake ID generator generating the sequential
+// IDs <prefix>-1, <prefix>-2 and so on.
+func NewFakeIDGenerator(prefix string) IDGenerator {
+	newGenerator := &fakeIDGenerator{
+		// Internals.
+		mutex: sync.Mutex{},
+		next:  1,
+
+		// Settings.
+		prefix: prefix,
+	}
+
+	return newGenerator
+}
+
+// NewIDGenerator creates a new ID generator backed by a single ID service.
+func NewIDGenerator() (IDGenerator, error) {
+	idConfig := id.DefaultServiceConfig()
+	idService, err := id.NewService(idConfig)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	newGenerator := &idGenerator{
+		// Dependencies.
+		id: idService,
+	}
+
+	return newGenerator, nil
+}
+
+// defaultIDGenerator returns the ID generator shared by all events and signals
+// not configured with an ID generator of their own, so that creating an event
+// does not allocate a new ID service.
+func defaultIDGenerator() (IDGenerator, error) {
+	sharedIDGeneratorOnce.Do(func() {
+		sharedIDGenerator, sharedIDGeneratorErr = NewIDGenerator()
+	})
+	if sharedIDGeneratorErr != nil {
+		return nil, maskAny(sharedIDGeneratorErr)
+	}
+
+	return sharedIDGenerator, nil
+}
+
+type clock struct{}
+
+func (c *clock) NewTicker(d time.Duration) Ticker {
+	return &ticker{ticker: time.NewTicker(d)}
+}
+
+func (c *clock) Now() time.Time {
+	return time.Now()
+}
+
+type fakeClock struct {
+	// Internals.
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	c.tick()
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	newTicker := &fakeTicker{
+		// Internals.
+		c:       make(chan time.Time, 1),
+		clock:   c,
+		next:    c.now.Add(d),
+		stopped: false,
+
+		// Settings.
+		d: d,
+	}
+	c.tickers = append(c.tickers, newTicker)
+
+	return newTicker
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = now
+	c.tick()
+}
+
+// tick delivers a tick on every ticker whose duration passed. Like time.Ticker,
+// ticks are dropped in case the receiver is not ready, so a ticker delivers a
+// single tick even if its duration passed multiple times. The caller must hold
+// the clock's mutex.
+func (c *fakeClock) tick() {
+	var active []*fakeTicker
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue
+		}
+		active = append(active, t)
+
+		if c.now.Before(t.next) {
+			continue
+		}
+		for !c.now.Before(t.next) {
+			t.next = t.next.Add(t.d)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.tickers = active
+}
+
+type fakeTicker struct {
+	// Internals.
+	c       chan time.Time
+	clock   *fakeClock
+	next    time.Time
+	stopped bool
+
+	// Settings.
+	d time.Duration
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	t.stopped = true
+}
+
+type fakeIDGenerator struct {
+	// Internals.
+	mutex sync.Mutex
+	next  int
+
+	// Settings.
+	prefix string
+}
+
+func (g *fakeIDGenerator) New() (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	newID := fmt.Sprintf("%s-%d", g.prefix, g.next)
+	g.next++
+
+	return newID, nil
+}
+
+type idGenerator struct {
+	// Dependencies.
+	id id.Service
+}
+
+func (g *idGenerator) New() (string, error) {
+	newID, err := g.id.New()
+	if err != nil {
+		return "", maskAny(err)
+	}
+
+	return newID, nil
+}
+
+type ticker struct {
+	// Dependencies.
+	ticker *time.Ticker
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *ticker) Stop() {
+	t.ticker.Stop()
+}
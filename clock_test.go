@@ -0,0 +1,146 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+// waitForTickers waits until the given number of tickers were created with the
+// given fake clock.
+func waitForTickers(t *testing.T, clock FakeClock, n int) {
+	c := clock.(*fakeClock)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mutex.Lock()
+		created := len(c.tickers)
+		c.mutex.Unlock()
+		if created >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("expected %d tickers", n)
+}
+
+func Test_FakeClock_Ticker(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ticker := clock.NewTicker(time.Second)
+
+	ticked := func() bool {
+		select {
+		case <-ticker.C():
+			return true
+		default:
+			return false
+		}
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if ticked() {
+		t.Fatal("expected no tick before the duration passed")
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !ticked() {
+		t.Fatal("expected tick after the duration passed")
+	}
+
+	// Ticks are dropped while the receiver is not ready.
+	clock.Advance(3 * time.Second)
+	if !ticked() {
+		t.Fatal("expected tick after the duration passed")
+	}
+	if ticked() {
+		t.Fatal("expected ticks to be dropped")
+	}
+
+	clock.Set(time.Unix(10, 0))
+	if !ticked() {
+		t.Fatal("expected tick after the time was set")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	if ticked() {
+		t.Fatal("expected no tick after the ticker was stopped")
+	}
+}
+
+func Test_Service_FlushPeriodically_FakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := newTestService(t, newMemoryStorage(), func(config *ServiceConfig) {
+		config.BatchInterval = time.Second
+		config.BatchSize = 100
+		config.Clock = clock
+	})
+	defer s.Shutdown()
+
+	f := s.CreateAsync(nil, newTestEvent(t, "1", "payload"), "a")
+	waitForTickers(t, clock, 1)
+
+	select {
+	case <-f.Done():
+		t.Fatal("expected event to be buffered until the batch interval passed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected event to be flushed after the batch interval passed")
+	}
+}
+
+func Test_NewFromCloudEventAttributes_Clock(t *testing.T) {
+	now := time.Unix(42, 0)
+	attributes := map[string]string{
+		"id":          "1",
+		"source":      "test",
+		"specversion": CloudEventsSpecVersion,
+		"type":        "test",
+	}
+
+	e, err := NewFromCloudEventAttributes(attributes, nil, WithClock(NewFakeClock(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Created().Equal(now) {
+		t.Fatalf("expected creation time %s, got %s", now, e.Created())
+	}
+}
+
+// foreignEvent is an Event implementation lacking ID and creation time.
+type foreignEvent struct {
+	headers map[string]string
+}
+
+func (e *foreignEvent) Created() time.Time           { return time.Time{} }
+func (e *foreignEvent) Headers() map[string]string   { return e.headers }
+func (e *foreignEvent) ID() string                   { return "" }
+func (e *foreignEvent) MarshalJSON() ([]byte, error) { return []byte("{}"), nil }
+func (e *foreignEvent) Payload() string              { return "" }
+func (e *foreignEvent) PayloadBytes() []byte         { return nil }
+func (e *foreignEvent) UnmarshalJSON(b []byte) error { return nil }
+
+func Test_Service_Stamp_CopiesHeaders(t *testing.T) {
+	clock := NewFakeClock(time.Unix(42, 0))
+	s := newTestService(t, newMemoryStorage(), func(config *ServiceConfig) {
+		config.Clock = clock
+	})
+
+	e := &foreignEvent{headers: map[string]string{HeaderSource: "test"}}
+	stamped, err := s.stamp(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.headers[HeaderSource] = "changed"
+
+	if stamped.Headers()[HeaderSource] != "test" {
+		t.Fatalf("expected headers to be copied, got %v", stamped.Headers())
+	}
+	if stamped.ID() != "id-1" || !stamped.Created().Equal(clock.Now()) {
+		t.Fatalf("expected event to be stamped, got %s created at %s", stamped.ID(), stamped.Created())
+	}
+}
@@ -0,0 +1,242 @@
+package event
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion represents the version of the CloudEvents
+	// specification events are converted from and to.
+	CloudEventsSpecVersion = "1.0"
+)
+
+var (
+	// cloudEventsHeaders maps the CloudEvents attributes stored in headers to
+	// the names of their headers. All other attributes are stored in headers
+	// named after them.
+	cloudEventsHeaders = map[string]string{
+		"compactionkey":   HeaderCompactionKey,
+		"correlationid":   HeaderCorrelationID,
+		"datacontenttype": HeaderContentType,
+		"schemaversion":   HeaderSchemaVersion,
+		"source":          HeaderSource,
+		"type":            HeaderType,
+	}
+	// cloudEventsReserved holds the CloudEvents attributes not stored in
+	// headers.
+	cloudEventsReserved = map[string]bool{
+		"data":        true,
+		"data_base64": true,
+		"id":          true,
+		"specversion": true,
+		"time":        true,
+	}
+)
+
+// CloudEventAttributes returns the CloudEvents 1.0 attributes of the given
+// event, as used by the binary content mode. The payload of the event is the
+// event data. The ID and creation time of the event are mapped to the id and
+// time attributes, all other attributes are taken from the event's headers.
+// Events have to carry the HeaderSource and HeaderType headers, because the
+// source and type attributes are required.
+func CloudEventAttributes(e Event) (map[string]string, error) {
+	attributes := map[string]string{
+		"id":          e.ID(),
+		"specversion": CloudEventsSpecVersion,
+	}
+	if !e.Created().IsZero() {
+		attributes["time"] = e.Created().UTC().Format(time.RFC3339Nano)
+	}
+
+	for name, value := range e.Headers() {
+		attribute := cloudEventsAttribute(name)
+		if !isCloudEventsAttribute(attribute) || cloudEventsReserved[attribute] {
+			return nil, maskAnyf(invalidExecutionError, "header %s must map to a CloudEvents attribute", name)
+		}
+		attributes[attribute] = value
+	}
+
+	if attributes["id"] == "" || attributes["source"] == "" || attributes["type"] == "" {
+		return nil, maskAnyf(invalidExecutionError, "event %s must have id, source and type", e.ID())
+	}
+
+	return attributes, nil
+}
+
+// NewFromCloudEventAttributes creates a new event from the given CloudEvents
+// 1.0 attributes and data, as received in the binary content mode. See
+// CloudEventAttributes for the mapping of attributes. Events without time
+// attribute are considered created when they were received, according to the
+// clock given using WithClock, or the system clock. Options configuring the ID,
+// headers or payload of the event are overwritten by the attributes and data.
+func NewFromCloudEventAttributes(attributes map[string]string, data []byte, options ...Option) (Event, error) {
+	if attributes["specversion"] != CloudEventsSpecVersion {
+		return nil, maskAnyf(invalidExecutionError, "specversion must be %s", CloudEventsSpecVersion)
+	}
+	if attributes["id"] == "" || attributes["source"] == "" || attributes["type"] == "" {
+		return nil, maskAnyf(invalidExecutionError, "id, source and type must not be empty")
+	}
+
+	headers := map[string]string{}
+	for attribute, value := range attributes {
+		if cloudEventsReserved[attribute] {
+			continue
+		}
+		headers[cloudEventsHeader(attribute)] = value
+	}
+
+	options = append(options,
+		WithHeaders(headers),
+		WithID(attributes["id"]),
+		WithPayloadBytes(append([]byte(nil), data...)),
+	)
+	if t, ok := attributes["time"]; ok {
+		created, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, maskAnyf(invalidExecutionError, "time must be RFC 3339: %s", err)
+		}
+		options = append(options, WithCreated(created))
+	}
+
+	newEvent, err := NewEvent(options...)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newEvent, nil
+}
+
+// MarshalCloudEvent returns the CloudEvents 1.0 JSON structured representation
+// of the given event. Payloads of JSON media types, or of no media type, that
+// hold valid JSON are embedded as data. All other payloads are encoded as
+// data_base64. See CloudEventAttributes for the mapping of attributes.
+func MarshalCloudEvent(e Event) ([]byte, error) {
+	attributes, err := CloudEventAttributes(e)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	m := map[string]interface{}{}
+	for attribute, value := range attributes {
+		m[attribute] = value
+	}
+
+	payload := e.PayloadBytes()
+	if payload != nil {
+		var raw json.RawMessage
+		if isJSONMediaType(attributes["datacontenttype"]) && json.Unmarshal(payload, &raw) == nil {
+			m["data"] = raw
+		} else {
+			m["data_base64"] = base64.StdEncoding.EncodeToString(payload)
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
+
+// UnmarshalCloudEvent creates a new event from the given CloudEvents 1.0 JSON
+// structured representation. Extension attributes that are not strings are
+// stored in headers using their JSON representation. See CloudEventAttributes
+// for the mapping of attributes and NewFromCloudEventAttributes for the given
+// options.
+func UnmarshalCloudEvent(b []byte, options ...Option) (Event, error) {
+	var m map[string]json.RawMessage
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	attributes := map[string]string{}
+	for attribute, raw := range m {
+		if attribute == "data" || attribute == "data_base64" {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			attributes[attribute] = s
+		} else {
+			attributes[attribute] = string(raw)
+		}
+	}
+
+	var data []byte
+	if raw, ok := m["data_base64"]; ok {
+		var s string
+		err := json.Unmarshal(raw, &s)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		data, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	} else if raw, ok := m["data"]; ok {
+		// Data of other than JSON media types is embedded as JSON string.
+		var s string
+		if !isJSONMediaType(attributes["datacontenttype"]) && json.Unmarshal(raw, &s) == nil {
+			data = []byte(s)
+		} else {
+			data = []byte(raw)
+		}
+	}
+
+	newEvent, err := NewFromCloudEventAttributes(attributes, data, options...)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newEvent, nil
+}
+
+// cloudEventsAttribute returns the name of the CloudEvents attribute the given
+// header is mapped to.
+func cloudEventsAttribute(header string) string {
+	for attribute, h := range cloudEventsHeaders {
+		if h == header {
+			return attribute
+		}
+	}
+
+	return header
+}
+
+// cloudEventsHeader returns the name of the header the given CloudEvents
+// attribute is mapped to.
+func cloudEventsHeader(attribute string) string {
+	if h, ok := cloudEventsHeaders[attribute]; ok {
+		return h
+	}
+
+	return attribute
+}
+
+// isCloudEventsAttribute checks whether the given name is a valid CloudEvents
+// attribute name, which consists of lower case letters and digits only.
+func isCloudEventsAttribute(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isJSONMediaType checks whether the given media type describes JSON. Data
+// without media type is JSON according to the CloudEvents specification.
+func isJSONMediaType(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+
+	return mediaType == "" || mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,136 @@
+package event
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newCloudEventsTestEvent(t *testing.T, contentType string, payload []byte) Event {
+	headers := map[string]string{
+		HeaderCompactionKey: "k",
+		HeaderCorrelationID: "c",
+		HeaderSchemaVersion: "2",
+		HeaderSource:        "test",
+		HeaderType:          "test.created",
+		"extension":         "value",
+	}
+	if contentType != "" {
+		headers[HeaderContentType] = contentType
+	}
+
+	e, err := NewEvent(
+		WithCreated(time.Unix(42, 5).UTC()),
+		WithHeaders(headers),
+		WithID("1"),
+		WithPayloadBytes(payload),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func assertCloudEvent(t *testing.T, expected, e Event) {
+	if e.ID() != expected.ID() || !e.Created().Equal(expected.Created()) {
+		t.Fatalf("expected event %s created at %s, got %s created at %s", expected.ID(), expected.Created(), e.ID(), e.Created())
+	}
+	if !reflect.DeepEqual(e.Headers(), expected.Headers()) {
+		t.Fatalf("expected headers %#v, got %#v", expected.Headers(), e.Headers())
+	}
+	if string(e.PayloadBytes()) != string(expected.PayloadBytes()) {
+		t.Fatalf("expected payload %q, got %q", expected.PayloadBytes(), e.PayloadBytes())
+	}
+}
+
+func Test_CloudEvents_Structured(t *testing.T) {
+	testCases := []struct {
+		ContentType string
+		Payload     []byte
+		DataField   string
+	}{
+		{ContentType: "", Payload: []byte(`{"key":"value"}`), DataField: "data"},
+		{ContentType: "application/cloudevents+json", Payload: []byte(`[1,2]`), DataField: "data"},
+		{ContentType: "application/json", Payload: []byte("not json"), DataField: "data_base64"},
+		{ContentType: "application/octet-stream", Payload: []byte{0, 1, 2}, DataField: "data_base64"},
+	}
+
+	for _, tc := range testCases {
+		e := newCloudEventsTestEvent(t, tc.ContentType, tc.Payload)
+
+		b, err := MarshalCloudEvent(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]json.RawMessage
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m[tc.DataField]; !ok {
+			t.Fatalf("%s: expected payload as %s, got %s", tc.ContentType, tc.DataField, b)
+		}
+		for _, attribute := range []string{"compactionkey", "correlationid", "schemaversion", "extension"} {
+			if _, ok := m[attribute]; !ok {
+				t.Fatalf("%s: expected attribute %s, got %s", tc.ContentType, attribute, b)
+			}
+		}
+
+		decoded, err := UnmarshalCloudEvent(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertCloudEvent(t, e, decoded)
+	}
+}
+
+func Test_CloudEvents_Binary(t *testing.T) {
+	e := newCloudEventsTestEvent(t, "application/octet-stream", []byte{0, 1, 2})
+
+	attributes, err := CloudEventAttributes(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"compactionkey":   "k",
+		"correlationid":   "c",
+		"datacontenttype": "application/octet-stream",
+		"extension":       "value",
+		"id":              "1",
+		"schemaversion":   "2",
+		"source":          "test",
+		"specversion":     CloudEventsSpecVersion,
+		"time":            "1970-01-01T00:00:42.000000005Z",
+		"type":            "test.created",
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Fatalf("expected attributes %#v, got %#v", expected, attributes)
+	}
+
+	decoded, err := NewFromCloudEventAttributes(attributes, e.PayloadBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCloudEvent(t, e, decoded)
+}
+
+func Test_CloudEvents_InvalidHeader(t *testing.T) {
+	e, err := NewEvent(
+		WithHeaders(map[string]string{
+			HeaderSource:     "test",
+			HeaderType:       "test.created",
+			"Invalid-Header": "value",
+		}),
+		WithID("1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CloudEventAttributes(e)
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package event
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	// CodecGob represents the name of the codec using encoding/gob. Payloads are
+	// stored as raw bytes.
+	CodecGob = "gob"
+	// CodecJSON represents the name of the codec using encoding/json. Events
+	// encoded by it are stored as plain JSON envelopes, just like events stored
+	// before codecs were introduced. Payloads are encoded as base64, which makes
+	// them about a third larger. Binary payloads are better stored using
+	// CodecGob or CodecMessagePack.
+	CodecJSON = "json"
+	// CodecMessagePack represents the name of the codec using MessagePack.
+	// Payloads are stored as raw bytes.
+	CodecMessagePack = "msgpack"
+)
+
+// NewGobCodec creates a new codec using encoding/gob.
+func NewGobCodec() Codec {
+	return &gobCodec{}
+}
+
+// NewJSONCodec creates a new codec using encoding/json.
+func NewJSONCodec() Codec {
+	return &jsonCodec{}
+}
+
+// NewMessagePackCodec creates a new codec using MessagePack.
+func NewMessagePackCodec() Codec {
+	return &messagePackCodec{}
+}
+
+type gobCodec struct{}
+
+func (c *gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *gobCodec) Name() string {
+	return CodecGob
+}
+
+func (c *gobCodec) Unmarshal(b []byte, v interface{}) error {
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (c *jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
+
+func (c *jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (c *jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	err := json.Unmarshal(b, v)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+type messagePackCodec struct{}
+
+func (c *messagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
+
+func (c *messagePackCodec) Name() string {
+	return CodecMessagePack
+}
+
+func (c *messagePackCodec) Unmarshal(b []byte, v interface{}) error {
+	err := msgpack.Unmarshal(b, v)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newCodecTestService(t *testing.T, m *memoryStorage, codec Codec) *service {
+	return newTestService(t, m, func(config *ServiceConfig) {
+		config.Codec = codec
+	})
+}
+
+func newCodecTestEvent(t *testing.T, eventID string) Event {
+	e, err := NewEvent(
+		WithCreated(time.Unix(42, 5).UTC()),
+		WithHeaders(map[string]string{HeaderType: "test"}),
+		WithID(eventID),
+		WithPayloadBytes([]byte{0, 1, 2, 255}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func assertCodecEvent(t *testing.T, expected, e Event) {
+	if e.ID() != expected.ID() || !e.Created().Equal(expected.Created()) {
+		t.Fatalf("expected event %s created at %s, got %s created at %s", expected.ID(), expected.Created(), e.ID(), e.Created())
+	}
+	if !reflect.DeepEqual(e.Headers(), expected.Headers()) {
+		t.Fatalf("expected headers %#v, got %#v", expected.Headers(), e.Headers())
+	}
+	if !reflect.DeepEqual(e.PayloadBytes(), expected.PayloadBytes()) {
+		t.Fatalf("expected payload %v, got %v", expected.PayloadBytes(), e.PayloadBytes())
+	}
+}
+
+func Test_Service_Codec_RoundTrip(t *testing.T) {
+	for _, codec := range []Codec{NewGobCodec(), NewMessagePackCodec()} {
+		m := newMemoryStorage()
+		s := newCodecTestService(t, m, codec)
+
+		expected := newCodecTestEvent(t, "1")
+		create(t, s, expected)
+		if tag := storedTag(t, m, s.scopeOf(""), "1"); tag != codec.Name() {
+			t.Fatalf("expected event encoded using %s, got %q", codec.Name(), tag)
+		}
+
+		assertCodecEvent(t, expected, consume(t, s))
+	}
+}
+
+func Test_Service_Codec_Mixed(t *testing.T) {
+	m := newMemoryStorage()
+
+	// Events encoded by different codecs coexist within the same namespace and
+	// are decoded with the codec they were encoded with, regardless of the codec
+	// of the consuming service.
+	var expected []Event
+	for i, codec := range []Codec{NewGobCodec(), NewJSONCodec(), NewMessagePackCodec()} {
+		e := newCodecTestEvent(t, string(rune('1'+i)))
+		create(t, newCodecTestService(t, m, codec), e)
+		expected = append(expected, e)
+	}
+
+	for i, codec := range []Codec{NewMessagePackCodec(), NewGobCodec(), NewJSONCodec()} {
+		assertCodecEvent(t, expected[i], consume(t, newCodecTestService(t, m, codec)))
+	}
+}
+
+func Test_Service_Codec_NotSupported(t *testing.T) {
+	m := newMemoryStorage()
+	create(t, newCodecTestService(t, m, &namedCodec{name: "custom"}), newCodecTestEvent(t, "1"))
+
+	// Services not registering the codec cannot decode the event.
+	s := newCodecTestService(t, m, NewJSONCodec())
+	rawEvent := m.strings[s.scopeOf("").eventKey("1")]
+	_, err := decode("1", []byte(rawEvent), s.codecs)
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+
+	// Services registering the codec can.
+	s = newTestService(t, m, func(config *ServiceConfig) {
+		config.Codecs = []Codec{&namedCodec{name: "custom"}}
+	})
+	e, err := s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() != "1" {
+		t.Fatalf("expected event 1, got %s", e.ID())
+	}
+}
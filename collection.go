@@ -11,6 +11,9 @@ import (
 type CollectionConfig struct {
 	// Dependencies.
 	StorageCollection *storage.Collection
+
+	// Settings.
+	KeyPrefix string
 }
 
 // DefaultCollectionConfig provides a default configuration to create a new
@@ -30,6 +33,9 @@ func DefaultCollectionConfig() CollectionConfig {
 	config := CollectionConfig{
 		// Dependencies.
 		StorageCollection: storageCollection,
+
+		// Settings.
+		KeyPrefix: KeyPrefixDefault,
 	}
 
 	return config
@@ -42,14 +48,20 @@ func NewCollection(config CollectionConfig) (*Collection, error) {
 		return nil, maskAnyf(invalidConfigError, "storage collection must not be empty")
 	}
 
+	// Settings.
+	if config.KeyPrefix == "" {
+		return nil, maskAnyf(invalidConfigError, "key prefix must not be empty")
+	}
+
 	var err error
 
 	var activatorService Service
 	{
-		activatorConfig := DefaultServiceConfig()
-		activatorConfig.Kind = KindActivator
-		activatorConfig.StorageCollection = config.StorageCollection
-		activatorService, err = NewService(activatorConfig)
+		activatorService, err = NewServiceWithOptions(
+			WithKind(KindActivator),
+			WithKeyPrefix(config.KeyPrefix),
+			WithStorageCollection(config.StorageCollection),
+		)
 		if err != nil {
 			return nil, maskAny(err)
 		}
@@ -57,10 +69,11 @@ func NewCollection(config CollectionConfig) (*Collection, error) {
 
 	var networkService Service
 	{
-		networkConfig := DefaultServiceConfig()
-		networkConfig.Kind = KindNetwork
-		networkConfig.StorageCollection = config.StorageCollection
-		networkService, err = NewService(networkConfig)
+		networkService, err = NewServiceWithOptions(
+			WithKind(KindNetwork),
+			WithKeyPrefix(config.KeyPrefix),
+			WithStorageCollection(config.StorageCollection),
+		)
 		if err != nil {
 			return nil, maskAny(err)
 		}
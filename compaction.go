@@ -0,0 +1,310 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// CompactionDrop represents the compaction mode in which a compacted event
+	// is dropped from its queue and the new event is queued at the queue's head.
+	CompactionDrop = "drop"
+	// CompactionReplace represents the compaction mode in which a compacted
+	// event is replaced by the new event, which takes over the queue position as
+	// well as the ID of the compacted event.
+	CompactionReplace = "replace"
+
+	// compactedPayload is stored in place of the payload of events dropped by
+	// compaction. Event IDs pointing to it are skipped when consuming events.
+	compactedPayload = "!compacted"
+)
+
+// compact retains only the newest event per compaction key within the given
+// namespace. The compaction key is read from the HeaderCompactionKey header of
+// the given event. In case an older event with the same key is still queued,
+// it is either replaced or dropped, depending on the compaction mode. compact
+// returns true in case the given event replaced the queued event, which means
+// the given event must not be published anymore. The given event must already
+// be validated.
+func (s *service) compact(shard Storage, sc scope, namespace string, e Event) (bool, error) {
+	key := e.Headers()[HeaderCompactionKey]
+	if key == "" {
+		return false, nil
+	}
+	err := s.checkCompaction(e)
+	if err != nil {
+		return false, maskAny(err)
+	}
+
+	slotKey := sc.compactionKey(namespace, key)
+
+	// Look up the event currently queued for the compaction key. The reverse
+	// mapping of the event only exists as long as the event is queued. It is
+	// removed together with the slot as soon as the event is popped from its
+	// queue, deleted or trimmed off its queue.
+	var queuedID string
+	{
+		ok, err := shard.Exists(slotKey)
+		if err != nil {
+			return false, maskAny(err)
+		}
+		if ok {
+			eventID, err := shard.Get(slotKey)
+			if err != nil {
+				return false, maskAny(err)
+			}
+			ok, err := shard.Exists(sc.compactedEventKey(eventID))
+			if err != nil {
+				return false, maskAny(err)
+			}
+			if ok {
+				queuedID = eventID
+			}
+		}
+	}
+
+	if queuedID != "" {
+		switch s.compactionMode {
+		case CompactionReplace:
+			// The queue keeps referencing the ID of the compacted event, so the new
+			// event takes over its queue position as well as its ID. Consumers thus
+			// delete the payload under the key it is stored with. Overwriting the
+			// payload cleans up the payload of the compacted event at the same time.
+			newEvent := &event{
+				// Settings.
+				created: e.Created(),
+				headers: copyHeaders(e.Headers()),
+				id:      queuedID,
+				payload: e.PayloadBytes(),
+			}
+			b, err := s.marshal(sc, namespace, newEvent)
+			if err != nil {
+				return false, maskAny(err)
+			}
+			err = s.overwrite(shard, sc, queuedID, b)
+			if err != nil {
+				return false, maskAny(err)
+			}
+
+			// A consumer might have popped the compacted event while it was
+			// replaced, in which case it received either the compacted or the new
+			// event. The new event is then published on its own, so that it is not
+			// lost. Consumers might thus receive the new event twice.
+			ok, err := shard.Exists(sc.compactedEventKey(queuedID))
+			if err != nil {
+				return false, maskAny(err)
+			}
+			if ok {
+				return true, nil
+			}
+			err = s.remove(shard, sc, queuedID)
+			if err != nil {
+				return false, maskAny(err)
+			}
+		case CompactionDrop:
+			// The ID of the dropped event cannot be removed from the middle of its
+			// queue. Its payload is replaced with a small marker instead, so that
+			// consumers skip it.
+			err := s.overwrite(shard, sc, queuedID, []byte(compactedPayload))
+			if err != nil {
+				return false, maskAny(err)
+			}
+			err = shard.Remove(sc.compactedEventKey(queuedID))
+			if err != nil {
+				return false, maskAny(err)
+			}
+		}
+	}
+
+	// The mappings are written before the event is published, so a consumer
+	// popping the event right away finds the reverse mapping it has to remove.
+	err = shard.Set(slotKey, e.ID())
+	if err != nil {
+		return false, maskAny(err)
+	}
+	err = shard.Set(sc.compactedEventKey(e.ID()), slotKey)
+	if err != nil {
+		return false, maskAny(err)
+	}
+
+	return false, nil
+}
+
+// checkCompaction verifies that the given event can be compacted in case it
+// carries a compaction key.
+func (s *service) checkCompaction(e Event) error {
+	if e.Headers()[HeaderCompactionKey] != "" && s.compactionMode == "" {
+		return maskAnyf(invalidExecutionError, "compaction mode must be configured for header %s", HeaderCompactionKey)
+	}
+
+	return nil
+}
+
+// releaseCompacted marks the given event as not being queued anymore, so that
+// upcoming compactions do not replace it. The slot of its compaction key is
+// removed as well, unless a newer event took it over already.
+func (s *service) releaseCompacted(shard Storage, sc scope, eventID string) error {
+	if s.compactionMode == "" {
+		return nil
+	}
+
+	ok, err := shard.Exists(sc.compactedEventKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+	if !ok {
+		return nil
+	}
+	slotKey, err := shard.Get(sc.compactedEventKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	ok, err = shard.Exists(slotKey)
+	if err != nil {
+		return maskAny(err)
+	}
+	if ok {
+		slotID, err := shard.Get(slotKey)
+		if err != nil {
+			return maskAny(err)
+		}
+		if slotID == eventID {
+			err := shard.Remove(slotKey)
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+
+	err = shard.Remove(sc.compactedEventKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// moveCompaction copies the compaction keys of the queued events with the
+// given IDs from the source to the target shard. Slots the target holds already
+// belong to events published to the target while the namespace was moved. These
+// events are newer, which is why they keep their slots.
+func (s *service) moveCompaction(source, target Storage, sc scope, eventIDs []string) error {
+	if s.compactionMode == "" {
+		return nil
+	}
+
+	for _, eventID := range eventIDs {
+		ok, err := source.Exists(sc.compactedEventKey(eventID))
+		if err != nil {
+			return maskAny(err)
+		}
+		if !ok {
+			continue
+		}
+		slotKey, err := source.Get(sc.compactedEventKey(eventID))
+		if err != nil {
+			return maskAny(err)
+		}
+		slotID, err := source.Get(slotKey)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return maskAny(err)
+		}
+		if slotID != eventID {
+			continue
+		}
+		ok, err = target.Exists(slotKey)
+		if err != nil {
+			return maskAny(err)
+		}
+		if ok {
+			continue
+		}
+
+		err = target.Set(sc.compactedEventKey(eventID), slotKey)
+		if err != nil {
+			return maskAny(err)
+		}
+		err = target.Set(slotKey, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// compactedBy returns the compaction key the event with the given ID queued
+// within the given namespace is registered for. In case the event is not
+// registered for any compaction key, the empty string is returned.
+func (s *service) compactedBy(shard Storage, sc scope, namespace, eventID string) (string, error) {
+	ok, err := shard.Exists(sc.compactedEventKey(eventID))
+	if err != nil {
+		return "", maskAny(err)
+	}
+	if !ok {
+		return "", nil
+	}
+	slotKey, err := shard.Get(sc.compactedEventKey(eventID))
+	if err != nil {
+		return "", maskAny(err)
+	}
+	slotID, err := shard.Get(slotKey)
+	if isNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", maskAny(err)
+	}
+	prefix := sc.compactionKey(namespace, "")
+	if slotID != eventID || !strings.HasPrefix(slotKey, prefix) {
+		return "", nil
+	}
+
+	return strings.TrimPrefix(slotKey, prefix), nil
+}
+
+// restoreCompaction registers the event with the given ID queued within the
+// given namespace for the given compaction key, unless the key is empty.
+func (s *service) restoreCompaction(shard Storage, sc scope, namespace, eventID, key string) error {
+	if key == "" {
+		return nil
+	}
+
+	err := shard.Set(sc.compactedEventKey(eventID), sc.compactionKey(namespace, key))
+	if err != nil {
+		return maskAny(err)
+	}
+	err = shard.Set(sc.compactionKey(namespace, key), eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// uncompact releases the given event from its compaction key, because it could
+// not be published due to the given error. The given error is returned unless
+// releasing fails.
+func (s *service) uncompact(shard Storage, sc scope, eventID string, err error) error {
+	rerr := s.releaseCompacted(shard, sc, eventID)
+	if rerr != nil {
+		return maskAny(rerr)
+	}
+
+	return maskAny(err)
+}
+
+// redis string
+// holding the ID of the latest event queued for a compaction key, removed as
+// soon as the event is not queued anymore
+func (c scope) compactionKey(namespace, key string) string {
+	return fmt.Sprintf("%s:compaction:%s", c.namespaceKey(namespace), key)
+}
+
+// redis string
+// holding the compaction key of a queued event
+func (c scope) compactedEventKey(eventID string) string {
+	return fmt.Sprintf("%s:compaction", c.eventKey(eventID))
+}
@@ -0,0 +1,212 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newCompactionTestEvent(t *testing.T, eventID, payload, key string) Event {
+	config := DefaultConfig()
+	config.ID = eventID
+	config.Payload = payload
+	if key != "" {
+		config.Headers = map[string]string{HeaderCompactionKey: key}
+	}
+
+	e, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// hookStorage is a memoryStorage calling a hook before the next Set of a key.
+type hookStorage struct {
+	*memoryStorage
+
+	hooks map[string]func()
+}
+
+func (m *hookStorage) Set(key, value string) error {
+	m.mutex.Lock()
+	hook := m.hooks[key]
+	delete(m.hooks, key)
+	m.mutex.Unlock()
+
+	if hook != nil {
+		hook()
+	}
+
+	return m.memoryStorage.Set(key, value)
+}
+
+func newCompactionTestService(t *testing.T, m *memoryStorage, mode string) *service {
+	return newTestService(t, m, func(config *ServiceConfig) {
+		config.CompactionMode = mode
+	})
+}
+
+func create(t *testing.T, s *service, events ...Event) {
+	for _, e := range events {
+		err := s.Create(nil, e, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consume searches the next event and deletes it like a consumer does.
+func consume(t *testing.T, s *service) Event {
+	e, err := s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete(nil, e, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// assertDrained asserts that no event payload or compaction mapping is left
+// behind.
+func assertDrained(t *testing.T, m *memoryStorage, sc scope) {
+	keys := []string{sc.compactionKey("a", "k")}
+	for _, eventID := range []string{"1", "2", "3"} {
+		keys = append(keys, sc.eventKey(eventID), sc.compactedEventKey(eventID))
+	}
+
+	for _, key := range keys {
+		if _, ok := m.strings[key]; ok {
+			t.Fatalf("expected key %s to be removed", key)
+		}
+	}
+}
+
+func Test_Service_Compaction_Replace(t *testing.T) {
+	m := newMemoryStorage()
+	s := newCompactionTestService(t, m, CompactionReplace)
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create(t, s,
+		newCompactionTestEvent(t, "1", "old", "k"),
+		newCompactionTestEvent(t, "2", "other", ""),
+		newCompactionTestEvent(t, "3", "new", "k"),
+	)
+
+	// The newest event takes over the queue position and the ID of the event it
+	// replaced.
+	e := consume(t, s)
+	if e.ID() != "1" || e.Payload() != "new" {
+		t.Fatalf("expected event 1 with payload new, got %s with %s", e.ID(), e.Payload())
+	}
+	e = consume(t, s)
+	if e.ID() != "2" {
+		t.Fatalf("expected event 2, got %s", e.ID())
+	}
+
+	assertDrained(t, m, sc)
+}
+
+func Test_Service_Compaction_Drop(t *testing.T) {
+	m := newMemoryStorage()
+	s := newCompactionTestService(t, m, CompactionDrop)
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create(t, s,
+		newCompactionTestEvent(t, "1", "old", "k"),
+		newCompactionTestEvent(t, "2", "other", ""),
+		newCompactionTestEvent(t, "3", "new", "k"),
+	)
+
+	// The dropped event is skipped and the newest event is queued at the head.
+	e := consume(t, s)
+	if e.ID() != "2" {
+		t.Fatalf("expected event 2, got %s", e.ID())
+	}
+	e = consume(t, s)
+	if e.ID() != "3" || e.Payload() != "new" {
+		t.Fatalf("expected event 3 with payload new, got %s with %s", e.ID(), e.Payload())
+	}
+
+	assertDrained(t, m, sc)
+}
+
+func Test_Service_Compaction_Trimmed(t *testing.T) {
+	m := newMemoryStorage()
+	s := newCompactionTestService(t, m, CompactionReplace)
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create(t, s,
+		newCompactionTestEvent(t, "1", "old", "k"),
+		newCompactionTestEvent(t, "2", "other", ""),
+	)
+
+	// Trimming event 1 off its queue releases its compaction key, so that the
+	// next event of the key is queued instead of replacing a lost event.
+	err = s.Limit(nil, 1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(t, s, newCompactionTestEvent(t, "3", "new", "k"))
+
+	l := m.lists[sc.namespaceKey("a")]
+	if !reflect.DeepEqual(l, []string{"3", "2"}) {
+		t.Fatalf("expected queue [3 2], got %v", l)
+	}
+}
+
+func Test_Service_Compaction_NotConfigured(t *testing.T) {
+	s := newCompactionTestService(t, newMemoryStorage(), "")
+
+	err := s.Create(nil, newCompactionTestEvent(t, "1", "old", "k"), "a")
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+}
+
+func Test_Service_Compaction_ReplaceConsumed(t *testing.T) {
+	m := &hookStorage{memoryStorage: newMemoryStorage(), hooks: map[string]func(){}}
+	s := newTestService(t, m, func(config *ServiceConfig) {
+		config.CompactionMode = CompactionReplace
+	})
+	sc := s.scopeOf("")
+
+	err := s.Create(nil, newCompactionTestEvent(t, "1", "old", "k"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A consumer consumes the compacted event right before its payload is
+	// replaced.
+	var consumed Event
+	m.hooks[sc.eventKey("1")] = func() {
+		consumed = consume(t, s)
+	}
+	err = s.Create(nil, newCompactionTestEvent(t, "2", "new", "k"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed == nil || consumed.Payload() != "old" {
+		t.Fatalf("expected the compacted event to be consumed, got %#v", consumed)
+	}
+
+	// The new event is not lost, but published under its own ID.
+	e := consume(t, s)
+	if e.ID() != "2" || e.Payload() != "new" {
+		t.Fatalf("expected event 2 with payload new, got %s with %s", e.ID(), e.Payload())
+	}
+
+	assertDrained(t, m.memoryStorage, sc)
+}
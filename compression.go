@@ -0,0 +1,162 @@
+package event
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionGzip represents the compression of stored events using gzip.
+	CompressionGzip = "gzip"
+	// CompressionSnappy represents the compression of stored events using
+	// snappy.
+	CompressionSnappy = "snappy"
+	// CompressionZstd represents the compression of stored events using
+	// Zstandard.
+	CompressionZstd = "zstd"
+)
+
+var (
+	zstdDecoders     = map[int]*zstd.Decoder{}
+	zstdDecodersLock sync.Mutex
+	zstdEncoder      *zstd.Encoder
+	zstdEncoderErr   error
+	zstdEncoderOnce  sync.Once
+)
+
+// zstdEncoderShared returns the Zstandard encoder shared by all services. It is
+// safe for concurrent use by EncodeAll.
+func zstdEncoderShared() (*zstd.Encoder, error) {
+	zstdEncoderOnce.Do(func() {
+		zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+	})
+	if zstdEncoderErr != nil {
+		return nil, maskAny(zstdEncoderErr)
+	}
+
+	return zstdEncoder, nil
+}
+
+// zstdDecoderShared returns the Zstandard decoder shared by all services
+// refusing to decode more than the given number of bytes. It is safe for
+// concurrent use by DecodeAll.
+func zstdDecoderShared(maxSize int) (*zstd.Decoder, error) {
+	zstdDecodersLock.Lock()
+	defer zstdDecodersLock.Unlock()
+
+	if d, ok := zstdDecoders[maxSize]; ok {
+		return d, nil
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	zstdDecoders[maxSize] = d
+
+	return d, nil
+}
+
+// isCompression checks whether the given frame tag names a compression.
+func isCompression(tag string) bool {
+	return tag == CompressionGzip || tag == CompressionSnappy || tag == CompressionZstd
+}
+
+// compress compresses the given stored representation of an event in case
+// compression is configured and the representation exceeds the compression
+// threshold. Compressed representations are framed by the name of the
+// compression, so that compressed and uncompressed events can coexist within
+// the same namespace.
+func (s *service) compress(b []byte) ([]byte, error) {
+	if s.compression == "" || len(b) <= s.compressionThreshold {
+		return b, nil
+	}
+
+	var c []byte
+	switch s.compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(b)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		c = buf.Bytes()
+	case CompressionSnappy:
+		c = snappy.Encode(nil, b)
+	case CompressionZstd:
+		encoder, err := zstdEncoderShared()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		c = encoder.EncodeAll(b, nil)
+	}
+
+	return frame(s.compression, c), nil
+}
+
+// decompress reverts compress. The given stored representation is returned
+// as it is in case it is not compressed. Decompression does not depend on the
+// configured compression, so that the compression can be changed at any time.
+// Representations decompressing to more than the configured maximum size are
+// refused, so that a stored value cannot exhaust the memory of consumers.
+func (s *service) decompress(b []byte) ([]byte, error) {
+	tag, body, ok := unframe(b)
+	if !ok || !isCompression(tag) {
+		return b, nil
+	}
+
+	switch tag {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		defer r.Close()
+		d, err := ioutil.ReadAll(io.LimitReader(r, int64(s.maxDecompressedSize)+1))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if len(d) > s.maxDecompressedSize {
+			return nil, maskAnyf(invalidExecutionError, "decompressed event must not exceed %d bytes", s.maxDecompressedSize)
+		}
+
+		return d, nil
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if n > s.maxDecompressedSize {
+			return nil, maskAnyf(invalidExecutionError, "decompressed event must not exceed %d bytes", s.maxDecompressedSize)
+		}
+		d, err := snappy.Decode(nil, body)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return d, nil
+	default:
+		decoder, err := zstdDecoderShared(s.maxDecompressedSize)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		d, err := decoder.DecodeAll(body, nil)
+		if err == zstd.ErrDecoderSizeExceeded {
+			return nil, maskAnyf(invalidExecutionError, "decompressed event must not exceed %d bytes", s.maxDecompressedSize)
+		} else if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return d, nil
+	}
+}
@@ -0,0 +1,120 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func newCompressionTestService(t *testing.T, m *memoryStorage, compression string, threshold int) *service {
+	return newTestService(t, m, func(config *ServiceConfig) {
+		config.Compression = compression
+		config.CompressionThreshold = threshold
+	})
+}
+
+// storedTag returns the frame tag of the stored representation of the event
+// with the given ID, or the empty string in case it is not framed.
+func storedTag(t *testing.T, m *memoryStorage, sc scope, eventID string) string {
+	rawEvent, ok := m.strings[sc.eventKey(eventID)]
+	if !ok {
+		t.Fatalf("expected event %s to be stored", eventID)
+	}
+	tag, _, _ := unframe([]byte(rawEvent))
+
+	return tag
+}
+
+func Test_Service_Compression_RoundTrip(t *testing.T) {
+	payload := strings.Repeat("payload", 100)
+
+	for _, compression := range []string{CompressionGzip, CompressionSnappy, CompressionZstd} {
+		m := newMemoryStorage()
+		s := newCompressionTestService(t, m, compression, 0)
+
+		create(t, s, newTestEvent(t, "1", payload))
+		if tag := storedTag(t, m, s.scopeOf(""), "1"); tag != compression {
+			t.Fatalf("expected event compressed using %s, got %q", compression, tag)
+		}
+
+		e := consume(t, s)
+		if e.ID() != "1" || e.Payload() != payload {
+			t.Fatalf("%s: expected event 1 with its payload, got %s with %d bytes", compression, e.ID(), len(e.Payload()))
+		}
+	}
+}
+
+func Test_Service_Compression_Threshold(t *testing.T) {
+	m := newMemoryStorage()
+	s := newCompressionTestService(t, m, CompressionGzip, 100)
+	sc := s.scopeOf("")
+
+	// Only encoded events exceeding the threshold are compressed.
+	create(t, s,
+		newTestEvent(t, "1", "small"),
+		newTestEvent(t, "2", strings.Repeat("large", 100)),
+	)
+	if tag := storedTag(t, m, sc, "1"); tag == CompressionGzip {
+		t.Fatal("expected small event not to be compressed")
+	}
+	if tag := storedTag(t, m, sc, "2"); tag != CompressionGzip {
+		t.Fatalf("expected large event to be compressed, got %q", tag)
+	}
+
+	for _, expected := range []string{"1", "2"} {
+		e := consume(t, s)
+		if e.ID() != expected {
+			t.Fatalf("expected event %s, got %s", expected, e.ID())
+		}
+	}
+}
+
+func Test_Service_Compression_Uncompressed(t *testing.T) {
+	payload := strings.Repeat("payload", 100)
+	m := newMemoryStorage()
+
+	// Events stored before compression was enabled are consumed as they are, and
+	// compressed events are consumed after compression was disabled.
+	create(t, newCompressionTestService(t, m, "", 0), newTestEvent(t, "1", payload))
+	create(t, newCompressionTestService(t, m, CompressionZstd, 0), newTestEvent(t, "2", payload))
+
+	for _, s := range []*service{newCompressionTestService(t, m, CompressionSnappy, 0), newCompressionTestService(t, m, "", 0)} {
+		e := consume(t, s)
+		if e.Payload() != payload {
+			t.Fatalf("expected event %s with its payload, got %d bytes", e.ID(), len(e.Payload()))
+		}
+	}
+}
+
+func Test_Service_Compression_MaxDecompressedSize(t *testing.T) {
+	b := []byte(strings.Repeat("0", 1000))
+
+	for _, compression := range []string{CompressionGzip, CompressionSnappy, CompressionZstd} {
+		s := newTestService(t, newMemoryStorage(), func(config *ServiceConfig) {
+			config.Compression = compression
+			config.CompressionThreshold = 0
+			config.MaxDecompressedSize = 1000
+		})
+
+		c, err := s.compress(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := s.decompress(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d) != string(b) {
+			t.Fatalf("%s: expected %d decompressed bytes, got %d", compression, len(b), len(d))
+		}
+
+		// One byte more than allowed is refused.
+		c, err = s.compress(append(b, '0'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.decompress(c)
+		if !IsInvalidExecution(err) {
+			t.Fatalf("%s: expected invalid execution error, got %#v", compression, err)
+		}
+	}
+}
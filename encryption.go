@@ -0,0 +1,216 @@
+package event
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/the-anna-project/context"
+)
+
+const (
+	// encryptionTag frames stored events encrypted using AES-GCM. The body of
+	// the frame is the ID of the encrypting key, followed by frameSeparator, the
+	// nonce and the sealed stored representation of the event. The storage key
+	// of the event is authenticated as additional data.
+	encryptionTag = "aesgcm"
+)
+
+// newCiphers creates the AES-GCM ciphers of all keys of the given keyring.
+func newCiphers(keyring Keyring) (map[string]cipher.AEAD, error) {
+	ciphers := map[string]cipher.AEAD{}
+
+	for keyID, key := range keyring.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		ciphers[keyID] = aead
+	}
+
+	return ciphers, nil
+}
+
+// encrypt encrypts the given stored representation of the event stored under
+// the given storage key using the active key of the keyring. The storage key is
+// authenticated as additional data, so that an encrypted event cannot be moved
+// to another key. The representation is returned as it is in case no key is
+// active.
+func (s *service) encrypt(key string, b []byte) ([]byte, error) {
+	if s.keyring.Active == "" {
+		return b, nil
+	}
+
+	aead := s.ciphers[s.keyring.Active]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	body := make([]byte, 0, len(s.keyring.Active)+1+len(nonce)+len(b)+aead.Overhead())
+	body = append(body, s.keyring.Active...)
+	body = append(body, frameSeparator)
+	body = append(body, nonce...)
+	body = aead.Seal(body, nonce, b, []byte(key))
+
+	return frame(encryptionTag, body), nil
+}
+
+// decrypt reverts encrypt for the event stored under the given storage key.
+// Besides the active key, all keys of the keyring are available for
+// decryption, so that events encrypted before a key rotation can still be
+// read. Unencrypted representations are returned as they are in case no key is
+// active, or ServiceConfig.AcceptUnencrypted allows them. Otherwise they are
+// considered tampered, like representations failing authentication.
+func (s *service) decrypt(key string, b []byte) ([]byte, error) {
+	keyID, body, ok := s.encryptedBy(b)
+	if !ok {
+		if s.keyring.Active != "" && !s.acceptUnencrypted {
+			return nil, maskAnyf(tamperedError, "event %s must be encrypted", key)
+		}
+		return b, nil
+	}
+
+	d, err := s.open(key, keyID, body)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return d, nil
+}
+
+// open opens the given sealed representation of the event stored under the
+// given storage key using the key with the given ID.
+func (s *service) open(key string, keyID string, body []byte) ([]byte, error) {
+	aead, ok := s.ciphers[keyID]
+	if !ok {
+		return nil, maskAnyf(invalidExecutionError, "key %s is not available", keyID)
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, maskAnyf(invalidExecutionError, "encrypted event must not be truncated")
+	}
+
+	d, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, maskAnyf(tamperedError, "event %s must decrypt: %s", key, err)
+	}
+
+	return d, nil
+}
+
+// encryptedBy returns the ID of the key the given stored representation was
+// encrypted with, along with the nonce and the sealed representation. In case
+// the given representation is not encrypted, ok is false.
+func (s *service) encryptedBy(b []byte) (string, []byte, bool) {
+	tag, body, ok := unframe(b)
+	if !ok || tag != encryptionTag {
+		return "", nil, false
+	}
+
+	i := bytes.IndexByte(body, frameSeparator)
+	if i < 0 {
+		return "", nil, false
+	}
+
+	return string(body[:i]), body[i+1:], true
+}
+
+func (s *service) Reencrypt(ctx context.Context) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, name := range s.shards.All() {
+		shard := s.shards.Shard(name)
+
+		scopes, err := s.scopes(shard)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		for _, sc := range scopes {
+			namespaces, err := shard.GetAllFromSet(sc.tableKey())
+			if err != nil {
+				return maskAny(err)
+			}
+
+			for _, namespace := range namespaces {
+				err := s.reencryptNamespace(shard, sc, namespace)
+				if err != nil {
+					return maskAny(err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// reencryptNamespace rewrites all events of the given namespace that are not
+// encrypted with the active key of the keyring. Events are decrypted in case no
+// key is active, which allows to disable encryption. Events consumed while
+// their namespace is rewritten are not affected, because only existing payloads
+// are overwritten.
+func (s *service) reencryptNamespace(shard Storage, sc scope, namespace string) error {
+	eventIDs, err := shard.GetAllFromList(sc.namespaceKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, eventID := range eventIDs {
+		ok, err := shard.Exists(sc.eventKey(eventID))
+		if err != nil {
+			return maskAny(err)
+		}
+		if !ok {
+			continue
+		}
+		rawEvent, err := shard.Get(sc.eventKey(eventID))
+		if err != nil {
+			return maskAny(err)
+		}
+		if rawEvent == compactedPayload {
+			continue
+		}
+		rawEvent, err = s.load(shard, sc, eventID, rawEvent)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		keyID, body, encrypted := s.encryptedBy([]byte(rawEvent))
+		if encrypted && keyID == s.keyring.Active {
+			continue
+		}
+		if !encrypted && s.keyring.Active == "" {
+			continue
+		}
+
+		// Unencrypted events are the ones to migrate, so they are accepted here
+		// regardless of ServiceConfig.AcceptUnencrypted.
+		b := []byte(rawEvent)
+		if encrypted {
+			b, err = s.open(sc.eventKey(eventID), keyID, body)
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+		b, err = s.encrypt(sc.eventKey(eventID), b)
+		if err != nil {
+			return maskAny(err)
+		}
+		err = s.overwrite(shard, sc, eventID, b)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package event
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/the-anna-project/storage"
+)
+
+// withTestKeyring configures the given configuration to encrypt stored events.
+func withTestKeyring(config *ServiceConfig) {
+	config.Keyring = Keyring{
+		Active: "k1",
+		Keys:   map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	}
+}
+
+func Test_Service_Encryption_BoundToKey(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, withTestKeyring)
+
+	for _, eventID := range []string{"1", "2"} {
+		err := s.Create(nil, newTestEvent(t, eventID, "payload "+eventID), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving the ciphertext of the first event to the key of the second one must
+	// not pass as the second event.
+	sc := s.scopeOf("")
+	rawEvent, err := m.Get(sc.eventKey("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Set(sc.eventKey("2"), rawEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "payload 1" {
+		t.Fatalf("expected payload 1, got %s", e.Payload())
+	}
+	_, err = s.Search(nil, "a")
+	if !IsTampered(err) {
+		t.Fatalf("expected tampered error, got %#v", err)
+	}
+}
+
+func Test_Service_Encryption_Unencrypted(t *testing.T) {
+	m := newMemoryStorage()
+	plain := newTestService(t, m, nil)
+	err := plain.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain events must not be read while a key is active.
+	s := newTestService(t, m, withTestKeyring)
+	_, err = s.SearchAll(nil, "a")
+	if !IsTampered(err) {
+		t.Fatalf("expected tampered error, got %#v", err)
+	}
+
+	// Plain events are read while they are migrated.
+	migrating := newTestService(t, m, func(config *ServiceConfig) {
+		withTestKeyring(config)
+		config.AcceptUnencrypted = true
+	})
+	events, err := migrating.SearchAll(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Payload() != "payload" {
+		t.Fatalf("expected the plain event, got %#v", events)
+	}
+
+	// Reencrypt migrates plain events regardless of AcceptUnencrypted.
+	err = s.Reencrypt(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "payload" {
+		t.Fatalf("expected payload, got %s", e.Payload())
+	}
+}
+
+func Test_Service_Encryption_ImportOtherTenant(t *testing.T) {
+	m := newMemoryStorage()
+	a := newTestService(t, m, func(config *ServiceConfig) {
+		withTestKeyring(config)
+		config.Tenant = "a"
+	})
+	b := newTestService(t, m, func(config *ServiceConfig) {
+		withTestKeyring(config)
+		config.Tenant = "b"
+	})
+
+	err := a.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = a.Export(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Import(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := b.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "payload" {
+		t.Fatalf("expected payload, got %s", e.Payload())
+	}
+}
+
+// namedCodec is a JSON codec carrying the given name.
+type namedCodec struct {
+	jsonCodec
+
+	name string
+}
+
+func (c *namedCodec) Name() string {
+	return c.name
+}
+
+func Test_Service_Encryption_ReservedCodecName(t *testing.T) {
+	for _, name := range []string{"custom", encryptionTag} {
+		config := defaultServiceSettings()
+		config.Codecs = []Codec{&namedCodec{name: name}}
+		config.Kind = KindNetwork
+		config.StorageCollection = &storage.Collection{Event: newMemoryStorage()}
+		err := config.defaultDependencies()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = NewService(config)
+		if name == encryptionTag && !IsInvalidConfig(err) {
+			t.Fatalf("expected invalid config error for codec %s, got %#v", name, err)
+		} else if name != encryptionTag && err != nil {
+			t.Fatalf("expected codec %s to be accepted, got %#v", name, err)
+		}
+	}
+}
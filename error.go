@@ -22,6 +22,41 @@ func maskAnyf(err error, f string, v ...interface{}) error {
 	return newErr
 }
 
+// DecodeError represents the failure to decode the payload of an event into
+// the type of a TypedService.
+type DecodeError struct {
+	// Codec is the name of the codec failing to decode the payload.
+	Codec string
+	// Err is the error returned by the codec.
+	Err error
+	// Event is the event carrying the payload. Events consumed by
+	// TypedService.Search are consumed regardless of the error, so Event allows
+	// to delete or inspect them.
+	Event Event
+	// Namespace is the namespace TypedService.Search consumed Event from. It
+	// addresses Event as single label, e.g. for Service.Delete, in case Event
+	// was consumed using the wildcard label. It is empty in case the service
+	// does not tell it.
+	Namespace string
+	// Type is the name of the type the payload was decoded into.
+	Type string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode: event %s must be decodable into %s by codec %s: %s", e.Event.ID(), e.Type, e.Codec, e.Err)
+}
+
+// Unwrap returns the error returned by the codec.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsDecode asserts *DecodeError.
+func IsDecode(err error) bool {
+	_, ok := errgo.Cause(err).(*DecodeError)
+	return ok
+}
+
 var invalidConfigError = errgo.New("invalid config")
 
 // IsInvalidConfig asserts invalidConfigError.
@@ -43,9 +78,44 @@ func IsInvalidExecution(err error) bool {
 	return errgo.Cause(err) == invalidExecutionError
 }
 
+var invalidPayloadError = errgo.New("invalid payload")
+
+// IsInvalidPayload asserts invalidPayloadError.
+func IsInvalidPayload(err error) bool {
+	return errgo.Cause(err) == invalidPayloadError
+}
+
+var invalidSchemaError = errgo.New("invalid schema")
+
+// IsInvalidSchema asserts invalidSchemaError.
+func IsInvalidSchema(err error) bool {
+	return errgo.Cause(err) == invalidSchemaError
+}
+
 var notFoundError = errgo.New("not found")
 
 // IsNotFound asserts notFoundError.
 func IsNotFound(err error) bool {
 	return errgo.Cause(err) == notFoundError
 }
+
+var quotaExceededError = errgo.New("quota exceeded")
+
+// IsQuotaExceeded asserts quotaExceededError.
+func IsQuotaExceeded(err error) bool {
+	return errgo.Cause(err) == quotaExceededError
+}
+
+var tamperedError = errgo.New("tampered")
+
+// IsTampered asserts tamperedError.
+func IsTampered(err error) bool {
+	return errgo.Cause(err) == tamperedError
+}
+
+var unavailableError = errgo.New("unavailable")
+
+// IsUnavailable asserts unavailableError.
+func IsUnavailable(err error) bool {
+	return errgo.Cause(err) == unavailableError
+}
@@ -4,40 +4,92 @@ package event
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
+)
 
-	"github.com/the-anna-project/id"
+const (
+	// HeaderCompactionKey represents the header holding the compaction key of an
+	// event. Service.Create retains only the newest event per compaction key
+	// within a namespace. See ServiceConfig.CompactionMode.
+	HeaderCompactionKey = "compaction-key"
+	// HeaderContentType represents the header describing the media type of an
+	// event's payload.
+	HeaderContentType = "content-type"
+	// HeaderCorrelationID represents the header correlating events belonging to
+	// the same process.
+	HeaderCorrelationID = "correlation-id"
+	// HeaderSchemaVersion represents the header describing the version of the
+	// schema an event's payload follows, which is the version of the event's
+	// type. See UpcasterRegistry.
+	HeaderSchemaVersion = "schema-version"
+	// HeaderSource represents the header describing the component that created
+	// an event.
+	HeaderSource = "source"
+	// HeaderType represents the header describing the type of an event.
+	HeaderType = "type"
 )
 
 // Config represents the configuration used to create a new event.
 type Config struct {
+	// Dependencies.
+	// Clock provides the creation time of the event in case Created is empty.
+	Clock Clock
+	// IDGenerator provides the ID of the event in case ID is empty.
+	IDGenerator IDGenerator
+
 	// Settings.
+	// Codec optionally overwrites the codec of the service the event is created
+	// with. Consumers decode the event with the codec it was encoded with, so
+	// producers can migrate codecs gradually.
+	Codec   Codec
 	Created time.Time
+	// Headers holds metadata describing the event and its payload. See the
+	// Header constants for common headers.
+	Headers map[string]string
 	ID      string
+	// Type optionally sets the HeaderType header.
+	Type string
+	// Version optionally sets the HeaderSchemaVersion header in case it is
+	// greater than 0.
+	Version int
 	Payload string
+	// PayloadBytes takes precedence over Payload in case it is not nil. It allows
+	// to create events carrying binary payloads without converting them to a
+	// string first.
+	PayloadBytes []byte
 }
 
 // DefaultConfig provides a default configuration to create a new event by best
-// effort.
+// effort. The configuration carries a new ID and the current time, just like it
+// did before the clock and the ID generator were configurable. They are only
+// used in case Created or ID are cleared. See NewEvent for creating events
+// using an injected clock or ID generator.
 func DefaultConfig() Config {
-	var newID string
-	{
-		idConfig := id.DefaultServiceConfig()
-		idService, err := id.NewService(idConfig)
-		if err != nil {
-			panic(err)
-		}
-		newID, err = idService.New()
-		if err != nil {
-			panic(err)
-		}
+	clock := NewClock()
+	idGenerator, err := defaultIDGenerator()
+	if err != nil {
+		panic(err)
+	}
+	newID, err := idGenerator.New()
+	if err != nil {
+		panic(err)
 	}
 
 	config := Config{
+		// Dependencies.
+		Clock:       clock,
+		IDGenerator: idGenerator,
+
 		// Settings.
-		Created: time.Now(),
-		ID:      newID,
-		Payload: "",
+		Codec:        nil,
+		Created:      clock.Now(),
+		Headers:      nil,
+		ID:           newID,
+		Type:         "",
+		Version:      0,
+		Payload:      "",
+		PayloadBytes: nil,
 	}
 
 	return config
@@ -45,6 +97,18 @@ func DefaultConfig() Config {
 
 // New creates a new configured event.
 func New(config Config) (Event, error) {
+	// Dependencies.
+	if config.Created.IsZero() && config.Clock != nil {
+		config.Created = config.Clock.Now()
+	}
+	if config.ID == "" && config.IDGenerator != nil {
+		newID, err := config.IDGenerator.New()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		config.ID = newID
+	}
+
 	// Settings.
 	if config.Created.IsZero() {
 		return nil, maskAnyf(invalidConfigError, "created must not be empty")
@@ -53,44 +117,198 @@ func New(config Config) (Event, error) {
 		return nil, maskAnyf(invalidConfigError, "id must not be empty")
 	}
 
+	if config.Version < 0 {
+		return nil, maskAnyf(invalidConfigError, "version must not be negative")
+	}
+
+	payload := config.PayloadBytes
+	if payload == nil {
+		payload = []byte(config.Payload)
+	}
+
 	newEvent := &event{
 		// Settings.
+		codec:   config.Codec,
 		created: config.Created,
+		headers: typeHeaders(copyHeaders(config.Headers), config.Type, config.Version),
 		id:      config.ID,
-		payload: config.Payload,
+		payload: payload,
+	}
+
+	return newEvent, nil
+}
+
+// encode returns the stored representation of the given event. Any Event
+// implementation is stored the same way, so that every stored event can be
+// decoded into an event. The given codec is used unless the event was created
+// with a codec of its own. Events encoded by CodecJSON are stored as plain JSON
+// envelopes. All other codecs are recorded within a frame, so that decode can
+// pick the codec the event was encoded with. The given signature is stored
+// along with the event, in case it is not empty.
+func encode(e Event, codec Codec, signature []byte) ([]byte, error) {
+	if ev, ok := e.(*event); ok && ev.codec != nil {
+		codec = ev.codec
 	}
 
-	b, err := json.Marshal(newEvent)
+	w := wireEvent{
+		Version:   wireVersion,
+		Created:   e.Created(),
+		Headers:   e.Headers(),
+		ID:        e.ID(),
+		Payload:   e.PayloadBytes(),
+		Signature: signature,
+	}
+
+	if codec == nil || codec.Name() == CodecJSON {
+		b, err := json.Marshal(w)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return b, nil
+	}
+
+	b, err := codec.Marshal(w)
 	if err != nil {
 		return nil, maskAny(err)
 	}
-	newEvent.payload = string(b)
+
+	return frame(codec.Name(), b), nil
+}
+
+// decode creates the event stored under the given event ID from its stored
+// representation. Other than New, decode does not generate a new ID, which the stored
+// payload would overwrite anyway. In case the stored payload does not carry an
+// ID, the event ID the payload was stored under is used. Framed payloads are
+// decoded with the codec named by their frame, which has to be one of the given
+// codecs. The signature of the event is not verified. See verify.
+func decode(eventID string, rawEvent []byte, codecs map[string]Codec) (*event, error) {
+	newEvent := &event{}
+
+	if name, body, ok := unframe(rawEvent); ok {
+		codec, ok := codecs[name]
+		if !ok {
+			return nil, maskAnyf(invalidExecutionError, "codec %s is not supported", name)
+		}
+
+		var w wireEvent
+		err := codec.Unmarshal(body, &w)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		err = newEvent.setWire(w)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		newEvent.codec = codec
+	} else {
+		err := newEvent.UnmarshalJSON(rawEvent)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+	if newEvent.id == "" {
+		newEvent.id = eventID
+	}
 
 	return newEvent, nil
 }
 
+// typeHeaders sets the type and version headers of the given headers in case
+// the given type and version are set.
+func typeHeaders(headers map[string]string, eventType string, version int) map[string]string {
+	if eventType == "" && version == 0 {
+		return headers
+	}
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	if eventType != "" {
+		headers[HeaderType] = eventType
+	}
+	if version > 0 {
+		headers[HeaderSchemaVersion] = strconv.Itoa(version)
+	}
+
+	return headers
+}
+
+// copyHeaders returns a copy of the given headers, so that events do not share
+// their headers with the caller.
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+
+	c := map[string]string{}
+	for k, v := range headers {
+		c[k] = v
+	}
+
+	return c
+}
+
 type event struct {
 	// Settings.
-	created time.Time `json:"created"`
-	id      string    `json:"id"`
-	payload string    `json:"payload"`
+	codec   Codec
+	created time.Time
+	headers map[string]string
+	id      string
+	payload []byte
+
+	// Internals.
+	signature []byte
+}
+
+const (
+	// wireVersion represents the version of the wire format written by
+	// event.MarshalJSON. It has to be incremented whenever the meaning of an
+	// existing field changes. New optional fields do not require a new version,
+	// because decoders ignore unknown fields.
+	wireVersion = 1
+)
+
+// wireEvent is the versioned envelope representing an event on the wire. The
+// JSON encoding represents the payload as base64, so that binary payloads
+// survive it at the cost of a third more bytes. Other codecs, like gob and
+// MessagePack, represent the payload as raw bytes.
+//
+// Events stored before the envelope was introduced lack the version field.
+// They are whatever JSON object the stored event marshalled to, e.g. the empty
+// object of the event's unexported fields, or the object of a custom Event
+// implementation. Such events were read back with the whole stored object as
+// payload, which is what decoding them still does, regardless of the fields
+// the object carries.
+type wireEvent struct {
+	Version int               `json:"version,omitempty"`
+	Created time.Time         `json:"created"`
+	Headers map[string]string `json:"headers,omitempty"`
+	ID      string            `json:"id"`
+	Payload []byte            `json:"payload"`
+	// Signature is the HMAC signature of the event, in case the event service
+	// storing the event was configured to sign events.
+	Signature []byte `json:"signature,omitempty"`
 }
 
 func (e *event) Created() time.Time {
 	return e.created
 }
 
+func (e *event) Headers() map[string]string {
+	return e.headers
+}
+
 func (e *event) ID() string {
 	return e.id
 }
 
 func (e *event) MarshalJSON() ([]byte, error) {
-	type Clone event
-
-	b, err := json.Marshal(&struct {
-		*Clone
-	}{
-		Clone: (*Clone)(e),
+	b, err := json.Marshal(wireEvent{
+		Version: wireVersion,
+		Created: e.created,
+		Headers: e.headers,
+		ID:      e.id,
+		Payload: e.payload,
 	})
 	if err != nil {
 		return nil, maskAny(err)
@@ -100,23 +318,59 @@ func (e *event) MarshalJSON() ([]byte, error) {
 }
 
 func (e *event) UnmarshalJSON(b []byte) error {
-	type Clone event
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	// Only objects carrying a positive version are envelopes. The fields of
+	// unversioned objects are not looked at, because they are not ours.
+	var version int
+	if json.Unmarshal(fields["version"], &version) != nil || version < 1 {
+		// The given bytes must not be retained after returning.
+		e.created = time.Time{}
+		e.headers = nil
+		e.id = ""
+		e.payload = append([]byte(nil), b...)
+		e.signature = nil
 
-	aux := &struct {
-		*Clone
-	}{
-		Clone: (*Clone)(e),
+		return nil
 	}
-	err := json.Unmarshal(b, &aux)
+
+	var w wireEvent
+	err = json.Unmarshal(b, &w)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = e.setWire(w)
 	if err != nil {
 		return maskAny(err)
 	}
-
-	e.payload = string(b)
 
 	return nil
 }
 
 func (e *event) Payload() string {
+	return string(e.payload)
+}
+
+func (e *event) PayloadBytes() []byte {
 	return e.payload
 }
+
+// setWire sets the fields of the event from the given envelope, regardless of
+// the codec the envelope was decoded with.
+func (e *event) setWire(w wireEvent) error {
+	if w.Version > wireVersion {
+		return maskAnyf(invalidExecutionError, "wire version %d is not supported", w.Version)
+	}
+
+	e.created = w.Created
+	e.headers = w.Headers
+	e.id = w.ID
+	e.payload = w.Payload
+	e.signature = w.Signature
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package event
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// newTestEvent creates an event with the given ID and payload. An empty ID is
+// generated. All test events are created at the same time, so that their stored
+// size only depends on their ID and payload.
+func newTestEvent(t testing.TB, eventID, payload string) Event {
+	config := DefaultConfig()
+	config.Created = time.Unix(1, 0).UTC()
+	config.ID = eventID
+	config.Payload = payload
+
+	e, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// goldenEvent is the event the golden file of the current wire version holds.
+func goldenEvent(t *testing.T) Event {
+	e, err := New(Config{
+		Created:      time.Date(2017, 1, 2, 3, 4, 5, 6, time.UTC),
+		Headers:      map[string]string{HeaderSource: "test"},
+		ID:           "e1",
+		PayloadBytes: []byte{0, 1, 2, 0xff},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.TrimSpace(b)
+}
+
+func Test_Event_Wire_Golden(t *testing.T) {
+	b, err := encode(goldenEvent(t), NewJSONCodec(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		err := ioutil.WriteFile(filepath.Join("testdata", "wire_v1.golden"), append(b, '\n'), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(b, readGolden(t, "wire_v1.golden")) {
+		t.Fatalf("expected golden wire format, got %s", b)
+	}
+}
+
+func Test_Event_Wire_Decode(t *testing.T) {
+	testCases := []struct {
+		Golden   string
+		Expected *event
+	}{
+		{
+			// The current wire version is decoded through setWire.
+			Golden: "wire_v1.golden",
+			Expected: &event{
+				created: time.Date(2017, 1, 2, 3, 4, 5, 6, time.UTC),
+				headers: map[string]string{HeaderSource: "test"},
+				id:      "e1",
+				payload: []byte{0, 1, 2, 0xff},
+			},
+		},
+		{
+			// The pre-envelope format is the empty object the unexported fields
+			// marshalled to. The whole object is the payload and the ID is the one
+			// the event is stored under.
+			Golden: "wire_legacy.golden",
+			Expected: &event{
+				id:      "stored",
+				payload: []byte("{}"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		e, err := decode("stored", readGolden(t, tc.Golden), nil)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Golden, err)
+		}
+		if !reflect.DeepEqual(e, tc.Expected) {
+			t.Fatalf("%s: expected %#v, got %#v", tc.Golden, tc.Expected, e)
+		}
+	}
+}
+
+func Test_Event_Wire_DecodeUnversioned(t *testing.T) {
+	// Custom Event implementations stored whatever object they marshalled to.
+	// Unversioned objects are never decoded as envelopes, even if they carry
+	// fields of the same name.
+	testCases := []string{
+		`{"created":"2017-01-02T03:04:05Z","id":"custom","payload":"cGF5bG9hZA=="}`,
+		`{"id":"custom","payload":"not base64"}`,
+		`{"id":1,"version":"1"}`,
+	}
+
+	for _, tc := range testCases {
+		e, err := decode("stored", []byte(tc), nil)
+		if err != nil {
+			t.Fatalf("%s: %s", tc, err)
+		}
+		if e.ID() != "stored" || e.Payload() != tc {
+			t.Fatalf("%s: expected the whole object as payload of event stored, got %s of event %s", tc, e.Payload(), e.ID())
+		}
+	}
+}
+
+func Test_Event_Wire_UnsupportedVersion(t *testing.T) {
+	_, err := decode("stored", readGolden(t, "wire_v2.golden"), nil)
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+
+	err = (&event{}).setWire(wireEvent{Version: wireVersion + 1})
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+}
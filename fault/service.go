@@ -0,0 +1,289 @@
+// Package fault implements a storage service injecting faults into the
+// operations of another storage service. It is meant to be used in tests to
+// reproduce the partial failures event services and their consumers have to
+// cope with, e.g. a failing Set after a successful PushToList within
+// Service.Create. All random decisions are driven by a seeded random number
+// generator, so that failures are reproducible.
+package fault
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/the-anna-project/storage"
+)
+
+// Fault represents a fault injected into a storage operation.
+type Fault struct {
+	// Operation is the name of the storage method the fault is injected into,
+	// e.g. Set or PopFromList.
+	Operation string
+	// After restricts the fault to operations following a successful call of
+	// the storage method with the given name. Every successful call arms the
+	// fault for exactly one following call of Operation, so that concurrent
+	// callers, e.g. publishing into different namespaces, are each matched
+	// regardless of how their calls interleave. An empty After does not restrict
+	// the fault.
+	After string
+	// Count limits the number of times the fault is injected. Zero does not
+	// limit the fault.
+	Count int
+	// Delay is the latency added to the operation before it is executed.
+	Delay time.Duration
+	// Drop causes the operation to be skipped while reporting success. Dropped
+	// reads return zero values.
+	Drop bool
+	// Fail causes the operation to be skipped and an injected error to be
+	// returned. Failures can be asserted using IsInjected.
+	Fail bool
+	// Probability is the probability between 0 and 1 with which the fault is
+	// injected. Zero is treated as 1, so that faults are always injected by
+	// default.
+	Probability float64
+}
+
+// Config represents the configuration used to create a new fault injecting
+// storage service.
+type Config struct {
+	// Dependencies.
+	Storage storage.Service
+
+	// Settings.
+	Faults []Fault
+	Seed   int64
+}
+
+// DefaultConfig provides a default configuration to create a new fault
+// injecting storage service by best effort.
+func DefaultConfig() Config {
+	config := Config{
+		// Dependencies.
+		Storage: nil,
+
+		// Settings.
+		Faults: nil,
+		Seed:   0,
+	}
+
+	return config
+}
+
+// New creates a new configured fault injecting storage service. All methods
+// not used by the event service are passed through to the wrapped storage
+// service.
+func New(config Config) (storage.Service, error) {
+	// Dependencies.
+	if config.Storage == nil {
+		return nil, maskAnyf(invalidConfigError, "storage must not be empty")
+	}
+
+	// Settings.
+	for _, f := range config.Faults {
+		if f.Operation == "" {
+			return nil, maskAnyf(invalidConfigError, "fault operation must not be empty")
+		}
+		if f.Probability < 0 || f.Probability > 1 {
+			return nil, maskAnyf(invalidConfigError, "fault probability must be between 0 and 1")
+		}
+		if f.Count < 0 {
+			return nil, maskAnyf(invalidConfigError, "fault count must not be negative")
+		}
+		if f.Drop && f.Fail {
+			return nil, maskAnyf(invalidConfigError, "fault must not drop and fail at the same time")
+		}
+	}
+
+	newService := &service{
+		// Dependencies.
+		Service: config.Storage,
+
+		// Internals.
+		armed:    make([]int, len(config.Faults)),
+		injected: make([]int, len(config.Faults)),
+		mutex:    sync.Mutex{},
+		random:   rand.New(rand.NewSource(config.Seed)),
+
+		// Settings.
+		faults: config.Faults,
+	}
+
+	return newService, nil
+}
+
+type service struct {
+	// Dependencies.
+	storage.Service
+
+	// Internals.
+	// armed holds per fault the number of successful calls of its After
+	// operation not yet followed by a call of its Operation.
+	armed    []int
+	injected []int
+	mutex    sync.Mutex
+	random   *rand.Rand
+
+	// Settings.
+	faults []Fault
+}
+
+func (s *service) Exists(key string) (bool, error) {
+	var ok bool
+	err := s.do("Exists", func() error {
+		var err error
+		ok, err = s.Service.Exists(key)
+		return err
+	})
+	return ok, err
+}
+
+func (s *service) Get(key string) (string, error) {
+	var value string
+	err := s.do("Get", func() error {
+		var err error
+		value, err = s.Service.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *service) GetAllFromList(key string) ([]string, error) {
+	var values []string
+	err := s.do("GetAllFromList", func() error {
+		var err error
+		values, err = s.Service.GetAllFromList(key)
+		return err
+	})
+	return values, err
+}
+
+func (s *service) GetAllFromSet(key string) ([]string, error) {
+	var values []string
+	err := s.do("GetAllFromSet", func() error {
+		var err error
+		values, err = s.Service.GetAllFromSet(key)
+		return err
+	})
+	return values, err
+}
+
+func (s *service) GetRandomFromSet(key string) (string, error) {
+	var value string
+	err := s.do("GetRandomFromSet", func() error {
+		var err error
+		value, err = s.Service.GetRandomFromSet(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *service) PopFromList(key string) (string, error) {
+	var value string
+	err := s.do("PopFromList", func() error {
+		var err error
+		value, err = s.Service.PopFromList(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *service) PushToList(key string, element string) error {
+	return s.do("PushToList", func() error { return s.Service.PushToList(key, element) })
+}
+
+func (s *service) PushToSet(key string, element string) error {
+	return s.do("PushToSet", func() error { return s.Service.PushToSet(key, element) })
+}
+
+func (s *service) Remove(key string) error {
+	return s.do("Remove", func() error { return s.Service.Remove(key) })
+}
+
+func (s *service) RemoveFromSet(key string, element string) error {
+	return s.do("RemoveFromSet", func() error { return s.Service.RemoveFromSet(key, element) })
+}
+
+func (s *service) Set(key, value string) error {
+	return s.do("Set", func() error { return s.Service.Set(key, value) })
+}
+
+func (s *service) TrimEndOfList(key string, maxElements int) error {
+	return s.do("TrimEndOfList", func() error { return s.Service.TrimEndOfList(key, maxElements) })
+}
+
+// do executes the given storage operation and injects the first matching
+// fault into it.
+func (s *service) do(operation string, action func() error) error {
+	f, ok := s.match(operation)
+	if ok {
+		time.Sleep(f.Delay)
+	}
+
+	var err error
+	switch {
+	case ok && f.Drop:
+		err = nil
+	case ok && f.Fail:
+		err = maskAnyf(injectedError, "operation %s", operation)
+	default:
+		err = action()
+	}
+
+	if err != nil {
+		return maskAny(err)
+	}
+	s.arm(operation)
+
+	return nil
+}
+
+// arm arms the faults restricted to follow the given successful operation.
+func (s *service) arm(operation string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, f := range s.faults {
+		if f.After == operation {
+			s.armed[i]++
+		}
+	}
+}
+
+// match returns the first fault to inject into the given operation, if any.
+// The given operation disarms every fault it follows, whether the fault is
+// injected or not.
+func (s *service) match(operation string) (Fault, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	matched := -1
+	for i, f := range s.faults {
+		if f.Operation != operation {
+			continue
+		}
+		if f.After != "" {
+			if s.armed[i] == 0 {
+				continue
+			}
+			s.armed[i]--
+		}
+		if matched >= 0 {
+			continue
+		}
+		if f.Count > 0 && s.injected[i] >= f.Count {
+			continue
+		}
+		if f.Probability > 0 && s.random.Float64() >= f.Probability {
+			continue
+		}
+
+		matched = i
+	}
+
+	if matched < 0 {
+		return Fault{}, false
+	}
+	s.injected[matched]++
+
+	return s.faults[matched], true
+}
@@ -0,0 +1,177 @@
+package fault
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/the-anna-project/storage"
+)
+
+// memoryStorage is a storage service holding strings and lists in memory. The
+// methods not used by the tests are left unimplemented.
+type memoryStorage struct {
+	storage.Service
+
+	lists   map[string][]string
+	mutex   sync.Mutex
+	strings map[string]string
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		lists:   map[string][]string{},
+		strings: map[string]string{},
+	}
+}
+
+func (m *memoryStorage) Get(key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.strings[key], nil
+}
+
+func (m *memoryStorage) PushToList(key string, element string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lists[key] = append([]string{element}, m.lists[key]...)
+
+	return nil
+}
+
+func (m *memoryStorage) Set(key, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.strings[key] = value
+
+	return nil
+}
+
+func newTestService(t *testing.T, m *memoryStorage, seed int64, faults ...Fault) storage.Service {
+	config := DefaultConfig()
+	config.Faults = faults
+	config.Seed = seed
+	config.Storage = m
+
+	newService, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newService
+}
+
+func Test_Service_New_InvalidConfig(t *testing.T) {
+	testCases := []Fault{
+		{Operation: ""},
+		{Operation: "Set", Probability: 2},
+		{Operation: "Set", Count: -1},
+		{Operation: "Set", Drop: true, Fail: true},
+	}
+
+	for i, tc := range testCases {
+		config := DefaultConfig()
+		config.Faults = []Fault{tc}
+		config.Storage = newMemoryStorage()
+
+		_, err := New(config)
+		if !IsInvalidConfig(err) {
+			t.Fatalf("test case %d: expected invalid config error, got %#v", i+1, err)
+		}
+	}
+}
+
+func Test_Service_After(t *testing.T) {
+	s := newTestService(t, newMemoryStorage(), 0, Fault{Operation: "Set", After: "PushToList", Fail: true})
+
+	err := s.Set("a", "value")
+	if err != nil {
+		t.Fatalf("expected Set not following PushToList to succeed, got %#v", err)
+	}
+
+	err = s.PushToList("list", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Set("a", "value")
+	if !IsInjected(err) {
+		t.Fatalf("expected injected error, got %#v", err)
+	}
+	err = s.Set("a", "value")
+	if err != nil {
+		t.Fatalf("expected second Set to succeed, got %#v", err)
+	}
+}
+
+func Test_Service_After_Concurrent(t *testing.T) {
+	s := newTestService(t, newMemoryStorage(), 0, Fault{Operation: "Set", After: "PushToList", Fail: true})
+
+	// Every publisher pushes before it sets, so every Set is armed by one
+	// PushToList, however the calls of the publishers interleave.
+	var failed int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.PushToList("list", "a")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			err = s.Set("a", "value")
+			if IsInjected(err) {
+				mutex.Lock()
+				failed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed != 50 {
+		t.Fatalf("expected 50 injected failures, got %d", failed)
+	}
+}
+
+func Test_Service_Count_Drop(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, 0, Fault{Operation: "Set", Count: 1, Drop: true})
+
+	for _, v := range []string{"dropped", "stored"} {
+		err := s.Set(v, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := m.strings["dropped"]; ok {
+		t.Fatal("expected first Set to be dropped")
+	}
+	if _, ok := m.strings["stored"]; !ok {
+		t.Fatal("expected second Set to be stored")
+	}
+}
+
+func Test_Service_Probability_Seed(t *testing.T) {
+	failures := func(seed int64) []bool {
+		s := newTestService(t, newMemoryStorage(), seed, Fault{Operation: "Get", Fail: true, Probability: 0.5})
+
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			_, err := s.Get("a")
+			failed = append(failed, IsInjected(err))
+		}
+
+		return failed
+	}
+
+	if !reflect.DeepEqual(failures(1), failures(1)) {
+		t.Fatal("expected the same seed to inject the same failures")
+	}
+}
@@ -0,0 +1,42 @@
+package event
+
+import (
+	"bytes"
+)
+
+const (
+	// framePrefix starts every framed payload. No JSON document starts with it,
+	// so framed payloads and plain JSON envelopes can coexist within the same
+	// namespace.
+	framePrefix = '!'
+	// frameSeparator separates the tag of a frame from its body.
+	frameSeparator = ':'
+)
+
+// frame prefixes the given body with the given tag. The tag tells decoders how
+// to interpret the body, e.g. which codec encoded it. Tags must not contain
+// frameSeparator.
+func frame(tag string, body []byte) []byte {
+	b := make([]byte, 0, len(tag)+len(body)+2)
+	b = append(b, framePrefix)
+	b = append(b, tag...)
+	b = append(b, frameSeparator)
+	b = append(b, body...)
+
+	return b
+}
+
+// unframe splits the given framed payload into tag and body. In case the given
+// payload is not framed, ok is false.
+func unframe(b []byte) (string, []byte, bool) {
+	if len(b) == 0 || b[0] != framePrefix {
+		return "", nil, false
+	}
+
+	i := bytes.IndexByte(b, frameSeparator)
+	if i < 0 {
+		return "", nil, false
+	}
+
+	return string(b[1:i]), b[i+1:], true
+}
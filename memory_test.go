@@ -0,0 +1,319 @@
+package event
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/the-anna-project/storage"
+)
+
+// memoryStorage is an in-memory storage with the list semantics of the real
+// storage. Elements are pushed to the head of a list and popped from its end.
+// Other than the real storage, popping from an empty list does not block but
+// returns a not found error.
+type memoryStorage struct {
+	calls int
+	// latency is added to every operation to simulate storage round trips.
+	latency time.Duration
+	lists   map[string][]string
+	mutex   sync.Mutex
+	sets    map[string]map[string]bool
+	strings map[string]string
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		lists:   map[string][]string{},
+		sets:    map[string]map[string]bool{},
+		strings: map[string]string{},
+	}
+}
+
+// roundTrips returns the number of storage operations executed so far.
+func (m *memoryStorage) roundTrips() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.calls
+}
+
+func (m *memoryStorage) lock() {
+	if m.latency > 0 {
+		time.Sleep(m.latency)
+	}
+	m.mutex.Lock()
+	m.calls++
+}
+
+func (m *memoryStorage) Boot() {}
+
+func (m *memoryStorage) Exists(key string) (bool, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	_, s := m.strings[key]
+	_, l := m.lists[key]
+	_, t := m.sets[key]
+
+	return s || l || t, nil
+}
+
+func (m *memoryStorage) Get(key string) (string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	v, ok := m.strings[key]
+	if !ok {
+		return "", maskAnyf(notFoundError, key)
+	}
+
+	return v, nil
+}
+
+func (m *memoryStorage) GetAllFromList(key string) ([]string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	return append([]string(nil), m.lists[key]...), nil
+}
+
+func (m *memoryStorage) GetAllFromSet(key string) ([]string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	var elements []string
+	for e := range m.sets[key] {
+		elements = append(elements, e)
+	}
+
+	return elements, nil
+}
+
+func (m *memoryStorage) GetRandom() (string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	for k := range m.strings {
+		return k, nil
+	}
+
+	return "", maskAny(notFoundError)
+}
+
+func (m *memoryStorage) GetRandomFromSet(key string) (string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	for e := range m.sets[key] {
+		return e, nil
+	}
+
+	return "", maskAnyf(notFoundError, key)
+}
+
+func (m *memoryStorage) GetStringMap(key string) (map[string]string, error) {
+	return nil, maskAnyf(notFoundError, key)
+}
+
+func (m *memoryStorage) PopFromList(key string) (string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	l := m.lists[key]
+	if len(l) == 0 {
+		return "", maskAnyf(notFoundError, key)
+	}
+	e := l[len(l)-1]
+	if len(l) == 1 {
+		delete(m.lists, key)
+	} else {
+		m.lists[key] = l[:len(l)-1]
+	}
+
+	return e, nil
+}
+
+func (m *memoryStorage) PushToList(key string, element string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	m.lists[key] = append([]string{element}, m.lists[key]...)
+
+	return nil
+}
+
+func (m *memoryStorage) PushToSet(key string, element string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	if m.sets[key] == nil {
+		m.sets[key] = map[string]bool{}
+	}
+	m.sets[key][element] = true
+
+	return nil
+}
+
+func (m *memoryStorage) Remove(key string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	delete(m.strings, key)
+	delete(m.lists, key)
+	delete(m.sets, key)
+
+	return nil
+}
+
+func (m *memoryStorage) RemoveFromSet(key string, element string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	delete(m.sets[key], element)
+	if len(m.sets[key]) == 0 {
+		delete(m.sets, key)
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) Set(key, value string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	m.strings[key] = value
+
+	return nil
+}
+
+func (m *memoryStorage) SetStringMap(key string, stringMap map[string]string) error {
+	return nil
+}
+
+func (m *memoryStorage) Shutdown() {}
+
+func (m *memoryStorage) TrimEndOfList(key string, maxElements int) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	l := m.lists[key]
+	if len(l) > maxElements {
+		m.lists[key] = l[:maxElements]
+	}
+	if len(m.lists[key]) == 0 {
+		delete(m.lists, key)
+	}
+
+	return nil
+}
+
+// bulkStorage is a memoryStorage implementing Counter, ListAppender,
+// ListEvicter, ListLengther, MultiGetter and MultiPusher.
+type bulkStorage struct {
+	*memoryStorage
+}
+
+func (m bulkStorage) AppendManyToList(key string, elements []string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	m.lists[key] = append(m.lists[key], elements...)
+
+	return nil
+}
+
+func (m bulkStorage) EvictFromList(key string, n int) ([]string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	l := m.lists[key]
+	if n > len(l) {
+		n = len(l)
+	}
+	evicted := append([]string(nil), l[len(l)-n:]...)
+	m.lists[key] = l[:len(l)-n]
+	if len(m.lists[key]) == 0 {
+		delete(m.lists, key)
+	}
+
+	return evicted, nil
+}
+
+func (m bulkStorage) GetMany(keys []string) ([]string, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	var values []string
+	for _, k := range keys {
+		v, ok := m.strings[k]
+		if !ok {
+			return nil, maskAnyf(notFoundError, k)
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (m bulkStorage) IncrementBy(key string, delta int) (int, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	n, _ := strconv.Atoi(m.strings[key])
+	n += delta
+	m.strings[key] = strconv.Itoa(n)
+
+	return n, nil
+}
+
+func (m bulkStorage) LengthOfList(key string) (int, error) {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	return len(m.lists[key]), nil
+}
+
+func (m bulkStorage) PushManyToList(key string, elements []string) error {
+	m.lock()
+	defer m.mutex.Unlock()
+
+	for _, e := range elements {
+		m.lists[key] = append([]string{e}, m.lists[key]...)
+	}
+
+	return nil
+}
+
+// newTestService creates a booted service of kind KindNetwork backed by the
+// given storage. The given function may modify the configuration beforehand.
+func newTestService(t testing.TB, s storage.Service, f func(config *ServiceConfig)) *service {
+	newService := newUnbootedTestService(t, s, f)
+	newService.Boot()
+
+	return newService
+}
+
+// newUnbootedTestService creates a service like newTestService does, without
+// booting it.
+func newUnbootedTestService(t testing.TB, s storage.Service, f func(config *ServiceConfig)) *service {
+	config := defaultServiceSettings()
+	config.Kind = KindNetwork
+	config.IDGenerator = NewFakeIDGenerator("id")
+	config.StorageCollection = &storage.Collection{Event: s}
+	if f != nil {
+		f(&config)
+	}
+	err := config.defaultDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newService, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newService.(*service)
+}
@@ -0,0 +1,532 @@
+package event
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/the-anna-project/context"
+)
+
+// mirror is a storage writing every mutation through to a primary and a
+// secondary storage. Reads are served by the active storage, which is the
+// primary one until it failed the configured number of consecutive health
+// checks. Once the mirror failed over to the secondary storage it stays there
+// until it fails back, because the primary storage missed mutations while it
+// was unavailable and has to be resynchronized manually. The state of the
+// mirror is local to the process. Other processes using the same storages
+// detect failures on their own and might still write to the primary storage.
+type mirror struct {
+	// Dependencies.
+	primary   Storage
+	secondary Storage
+
+	// Internals.
+	failedOver bool
+	failures   int
+	mutex      sync.RWMutex
+	// writeMutex is held for reading by every mutation and for writing while
+	// failing back, so that no mutation is missed by the primary storage while
+	// it is verified.
+	writeMutex sync.RWMutex
+
+	// Settings.
+	diverged    func(key string, err error)
+	failover    func(err error)
+	maxFailures int
+}
+
+func newMirror(primary, secondary Storage, diverged func(key string, err error), failover func(err error), maxFailures int) *mirror {
+	m := &mirror{
+		// Dependencies.
+		primary:   primary,
+		secondary: secondary,
+
+		// Internals.
+		failedOver: false,
+		failures:   0,
+		mutex:      sync.RWMutex{},
+		writeMutex: sync.RWMutex{},
+
+		// Settings.
+		diverged:    diverged,
+		failover:    failover,
+		maxFailures: maxFailures,
+	}
+
+	return m
+}
+
+// Check verifies the health of the primary storage by looking up the given
+// key. In case the primary storage did not respond to the configured number of
+// consecutive checks, the mirror fails over to the secondary storage.
+func (m *mirror) Check(key string) {
+	if m.FailedOver() {
+		return
+	}
+
+	_, err := m.primary.Exists(key)
+
+	m.mutex.Lock()
+	if err == nil {
+		m.failures = 0
+		m.mutex.Unlock()
+		return
+	}
+	m.failures++
+	failover := m.failures >= m.maxFailures
+	if failover {
+		m.failedOver = true
+	}
+	m.mutex.Unlock()
+
+	if failover {
+		m.failover(maskAny(err))
+	}
+}
+
+// Failback switches back to the primary storage after the mirror failed over.
+// The given function verifies that the primary storage is healthy and holds
+// the data of the secondary storage. Mutations are blocked while it runs. In
+// case it returns an error, the mirror stays failed over. Failback returns
+// whether the mirror failed back.
+func (m *mirror) Failback(verify func() error) (bool, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if !m.FailedOver() {
+		return false, nil
+	}
+
+	err := verify()
+	if err != nil {
+		return false, maskAny(err)
+	}
+
+	m.mutex.Lock()
+	m.failedOver = false
+	m.failures = 0
+	m.mutex.Unlock()
+
+	return true, nil
+}
+
+// FailedOver returns whether the mirror serves all requests from the secondary
+// storage.
+func (m *mirror) FailedOver() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.failedOver
+}
+
+func (m *mirror) AppendManyToList(key string, elements []string) error {
+	return m.write(key, func(s Storage) error { return appendMany(s, key, elements) })
+}
+
+func (m *mirror) EvictFromList(key string, n int) ([]string, error) {
+	m.writeMutex.RLock()
+	defer m.writeMutex.RUnlock()
+
+	elements, err := evictFromList(m.active(), key, n)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	m.mirror(key, func(s Storage) error {
+		mirrored, err := evictFromList(s, key, len(elements))
+		if err != nil {
+			return maskAny(err)
+		}
+		if !reflect.DeepEqual(mirrored, elements) {
+			return maskAnyf(invalidExecutionError, "evicted %v instead of %v", mirrored, elements)
+		}
+
+		return nil
+	})
+
+	return elements, nil
+}
+
+func (m *mirror) Exists(key string) (bool, error) {
+	return m.active().Exists(key)
+}
+
+func (m *mirror) Get(key string) (string, error) {
+	return m.active().Get(key)
+}
+
+func (m *mirror) GetAllFromList(key string) ([]string, error) {
+	return m.active().GetAllFromList(key)
+}
+
+func (m *mirror) GetAllFromSet(key string) ([]string, error) {
+	return m.active().GetAllFromSet(key)
+}
+
+func (m *mirror) GetMany(keys []string) ([]string, error) {
+	return getMany(m.active(), keys)
+}
+
+func (m *mirror) GetRandomFromSet(key string) (string, error) {
+	return m.active().GetRandomFromSet(key)
+}
+
+func (m *mirror) IncrementBy(key string, delta int) (int, error) {
+	m.writeMutex.RLock()
+	defer m.writeMutex.RUnlock()
+
+	n, err := incrementBy(m.active(), key, delta)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+
+	m.mirror(key, func(s Storage) error {
+		_, err := incrementBy(s, key, delta)
+		return err
+	})
+
+	return n, nil
+}
+
+func (m *mirror) LengthOfList(key string) (int, error) {
+	return listLength(m.active(), key)
+}
+
+func (m *mirror) PopFromList(key string) (string, error) {
+	m.writeMutex.RLock()
+	defer m.writeMutex.RUnlock()
+
+	element, err := m.active().PopFromList(key)
+	if err != nil {
+		return "", maskAny(err)
+	}
+
+	m.mirror(key, func(s Storage) error {
+		// Popping from an empty list blocks, so the passive list is checked
+		// first. A missing list means the storages already diverged.
+		ok, err := s.Exists(key)
+		if err != nil {
+			return maskAny(err)
+		}
+		if !ok {
+			return maskAnyf(notFoundError, "list is empty")
+		}
+		mirrored, err := s.PopFromList(key)
+		if err != nil {
+			return maskAny(err)
+		}
+		if mirrored != element {
+			return maskAnyf(invalidExecutionError, "popped %s instead of %s", mirrored, element)
+		}
+
+		return nil
+	})
+
+	return element, nil
+}
+
+func (m *mirror) PushManyToList(key string, elements []string) error {
+	return m.write(key, func(s Storage) error { return pushMany(s, key, elements) })
+}
+
+func (m *mirror) PushToList(key string, element string) error {
+	return m.write(key, func(s Storage) error { return s.PushToList(key, element) })
+}
+
+func (m *mirror) PushToSet(key string, element string) error {
+	return m.write(key, func(s Storage) error { return s.PushToSet(key, element) })
+}
+
+func (m *mirror) Remove(key string) error {
+	return m.write(key, func(s Storage) error { return s.Remove(key) })
+}
+
+func (m *mirror) RemoveFromSet(key string, element string) error {
+	return m.write(key, func(s Storage) error { return s.RemoveFromSet(key, element) })
+}
+
+func (m *mirror) Set(key, value string) error {
+	return m.write(key, func(s Storage) error { return s.Set(key, value) })
+}
+
+func (m *mirror) TrimEndOfList(key string, maxElements int) error {
+	return m.write(key, func(s Storage) error { return s.TrimEndOfList(key, maxElements) })
+}
+
+func (m *mirror) active() Storage {
+	if m.FailedOver() {
+		return m.secondary
+	}
+
+	return m.primary
+}
+
+// mirror applies the given mutation to the passive storage. Failures do not
+// fail the operation, because the active storage already applied it. They are
+// reported as divergence instead.
+func (m *mirror) mirror(key string, mutation func(s Storage) error) {
+	if m.FailedOver() {
+		return
+	}
+
+	err := mutation(m.secondary)
+	if err != nil {
+		m.diverged(key, maskAny(err))
+	}
+}
+
+// write applies the given mutation to the active storage and mirrors it to the
+// passive one.
+func (m *mirror) write(key string, mutation func(s Storage) error) error {
+	m.writeMutex.RLock()
+	defer m.writeMutex.RUnlock()
+
+	err := mutation(m.active())
+	if err != nil {
+		return maskAny(err)
+	}
+
+	m.mirror(key, mutation)
+
+	return nil
+}
+
+func (s *service) CompareMirror(ctx context.Context) ([]string, error) {
+	err := s.bootError()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	if s.mirror == nil {
+		return nil, maskAnyf(invalidExecutionError, "secondary storage must be configured")
+	}
+
+	diverged, err := s.compareMirror()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	for _, key := range diverged {
+		s.diverged(key, maskAnyf(invalidExecutionError, "storages must hold the same data"))
+	}
+
+	return diverged, nil
+}
+
+func (s *service) Failback(ctx context.Context) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	if s.mirror == nil {
+		return maskAnyf(invalidExecutionError, "secondary storage must be configured")
+	}
+
+	ok, err := s.mirror.Failback(func() error {
+		_, err := s.mirror.primary.Exists(s.versionKey())
+		if err != nil {
+			return maskAny(err)
+		}
+		diverged, err := s.compareMirror()
+		if err != nil {
+			return maskAny(err)
+		}
+		if len(diverged) != 0 {
+			return maskAnyf(invalidExecutionError, "primary storage must be resynchronized, %d keys diverged", len(diverged))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return maskAny(err)
+	}
+	if ok {
+		s.count("Mirror.FailedBack")
+	}
+
+	return nil
+}
+
+// compareMirror returns the keys holding different data within the primary and
+// the secondary storage of the mirror.
+func (s *service) compareMirror() ([]string, error) {
+	c := &comparison{
+		primary:   s.mirror.primary,
+		secondary: s.mirror.secondary,
+	}
+
+	c.value(s.versionKey())
+	tenants := c.set(s.tenantsKey())
+
+	for _, tenant := range append([]string{""}, tenants...) {
+		sc := s.scopeOf(tenant)
+
+		for _, namespace := range c.set(sc.tableKey()) {
+			eventIDs := c.list(sc.namespaceKey(namespace))
+			eventIDs = append(eventIDs, c.list(sc.quarantineKey(namespace))...)
+
+			for _, eventID := range eventIDs {
+				c.event(sc, eventID)
+			}
+		}
+	}
+	if c.err != nil {
+		return nil, maskAny(c.err)
+	}
+
+	return c.diverged, nil
+}
+
+// comparison compares the data stored under the same keys within the primary
+// and the secondary storage of a mirror. Keys are compared one after the other,
+// so mutations applied while comparing might be reported as divergence.
+type comparison struct {
+	// Dependencies.
+	primary   Storage
+	secondary Storage
+
+	// Internals.
+	diverged []string
+	err      error
+	seen     map[string]bool
+}
+
+// compare reads the given key from both storages using the given function and
+// records the key as diverged in case the results differ. The results of both
+// storages are returned.
+func (c *comparison) compare(key string, read func(st Storage, key string) ([]string, error)) ([]string, []string) {
+	if c.err != nil {
+		return nil, nil
+	}
+	if c.seen == nil {
+		c.seen = map[string]bool{}
+	}
+	if c.seen[key] {
+		return nil, nil
+	}
+	c.seen[key] = true
+
+	p, err := read(c.primary, key)
+	if err != nil {
+		c.err = maskAny(err)
+		return nil, nil
+	}
+	q, err := read(c.secondary, key)
+	if err != nil {
+		c.err = maskAny(err)
+		return nil, nil
+	}
+	if len(p) == 0 {
+		p = nil
+	}
+	if len(q) == 0 {
+		q = nil
+	}
+	if !reflect.DeepEqual(p, q) {
+		c.diverged = append(c.diverged, key)
+	}
+
+	return p, q
+}
+
+// event compares the stored representation of the event with the given ID,
+// including its chunks.
+func (c *comparison) event(sc scope, eventID string) {
+	p, q := c.compare(sc.eventKey(eventID), readValue)
+
+	var chunks int
+	for _, rawEvent := range append(p, q...) {
+		m, _, err := manifest(rawEvent)
+		if err != nil {
+			// Manifests that cannot be parsed are compared as values.
+			continue
+		}
+		if m.Chunks > chunks {
+			chunks = m.Chunks
+		}
+	}
+	for i := 0; i < chunks; i++ {
+		c.compare(sc.chunkKey(eventID, i), readValue)
+	}
+}
+
+// list compares the list stored under the given key and returns the union of
+// the elements found within both storages.
+func (c *comparison) list(key string) []string {
+	p, q := c.compare(key, readList)
+
+	return union(p, q)
+}
+
+// set compares the set stored under the given key and returns the union of the
+// members found within both storages.
+func (c *comparison) set(key string) []string {
+	p, q := c.compare(key, readSet)
+
+	return union(p, q)
+}
+
+// value compares the value stored under the given key.
+func (c *comparison) value(key string) {
+	c.compare(key, readValue)
+}
+
+// readList reads the list stored under the given key.
+func readList(st Storage, key string) ([]string, error) {
+	elements, err := st.GetAllFromList(key)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return elements, nil
+}
+
+// readSet reads the set stored under the given key. Sets are not ordered, so
+// the members are sorted.
+func readSet(st Storage, key string) ([]string, error) {
+	members, err := st.GetAllFromSet(key)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	sort.Strings(members)
+
+	return members, nil
+}
+
+// readValue reads the value stored under the given key. A missing key reads as
+// no value.
+func readValue(st Storage, key string) ([]string, error) {
+	ok, err := st.Exists(key)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	value, err := st.Get(key)
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return []string{value}, nil
+}
+
+// union returns the elements of both given slices without duplicates, in the
+// order they are first found.
+func union(a, b []string) []string {
+	var u []string
+	seen := map[string]bool{}
+	for _, e := range append(append([]string(nil), a...), b...) {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		u = append(u, e)
+	}
+
+	return u
+}
@@ -0,0 +1,220 @@
+package event
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/the-anna-project/instrumentor"
+	"github.com/the-anna-project/storage"
+)
+
+// countingPublisher is an instrumentor publisher counting the increments of
+// its counters.
+type countingPublisher struct {
+	instrumentor.Publisher
+
+	counts map[string]float64
+	mutex  sync.Mutex
+}
+
+func (p *countingPublisher) GetCounter(key string) (instrumentor.Counter, error) {
+	return countingCounter{key: key, publisher: p}, nil
+}
+
+func (p *countingPublisher) count(key string) float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.counts[key]
+}
+
+type countingCounter struct {
+	key       string
+	publisher *countingPublisher
+}
+
+func (c countingCounter) IncrBy(delta float64) {
+	c.publisher.mutex.Lock()
+	defer c.publisher.mutex.Unlock()
+
+	c.publisher.counts[c.key] += delta
+}
+
+// newTestMirrorService creates a service mirroring the given primary storage to
+// the given secondary storage. Notified divergences and failovers are recorded.
+func newTestMirrorService(t *testing.T, primary, secondary storage.Service) (*service, *countingPublisher, *[]string, *[]error) {
+	publisher := &countingPublisher{counts: map[string]float64{}}
+	var diverged []string
+	var failedOver []error
+	s := newTestService(t, primary, func(config *ServiceConfig) {
+		config.InstrumentorCollection = &instrumentor.Collection{Publisher: publisher}
+		config.SecondaryStorageCollection = &storage.Collection{Event: secondary}
+		config.DivergenceNotifier = func(key string, err error) {
+			diverged = append(diverged, key)
+		}
+		config.FailoverNotifier = func(err error) {
+			failedOver = append(failedOver, err)
+		}
+	})
+
+	return s, publisher, &diverged, &failedOver
+}
+
+func Test_Service_Mirror_Failover(t *testing.T) {
+	primary := &gatedStorage{memoryStorage: newMemoryStorage()}
+	s, publisher, diverged, failedOver := newTestMirrorService(t, primary, newMemoryStorage())
+
+	s.mirror.Check(s.versionKey())
+	if s.mirror.FailedOver() || len(*failedOver) != 0 {
+		t.Fatal("expected the mirror not to fail over")
+	}
+
+	// Failures have to be consecutive.
+	primary.setFail(true)
+	s.mirror.Check(s.versionKey())
+	s.mirror.Check(s.versionKey())
+	primary.setFail(false)
+	s.mirror.Check(s.versionKey())
+	primary.setFail(true)
+	s.mirror.Check(s.versionKey())
+	s.mirror.Check(s.versionKey())
+	if s.mirror.FailedOver() {
+		t.Fatal("expected the mirror not to fail over before 3 consecutive failures")
+	}
+
+	s.mirror.Check(s.versionKey())
+	s.mirror.Check(s.versionKey())
+	if !s.mirror.FailedOver() {
+		t.Fatal("expected the mirror to fail over")
+	}
+	if len(*failedOver) != 1 {
+		t.Fatalf("expected one failover notification, got %d", len(*failedOver))
+	}
+	if len(*diverged) != 0 {
+		t.Fatalf("expected no divergence notification, got %#v", *diverged)
+	}
+	if n := publisher.count("Mirror.FailedOver"); n != 1 {
+		t.Fatalf("expected failover to be counted once, got %f", n)
+	}
+}
+
+func Test_Service_Mirror_Failback(t *testing.T) {
+	primary := &gatedStorage{memoryStorage: newMemoryStorage()}
+	secondary := newMemoryStorage()
+	s, publisher, _, _ := newTestMirrorService(t, primary, secondary)
+
+	primary.setFail(true)
+	for i := 0; i < 3; i++ {
+		s.mirror.Check(s.versionKey())
+	}
+	if !s.mirror.FailedOver() {
+		t.Fatal("expected the mirror to fail over")
+	}
+
+	// The primary storage misses the event created while the mirror is failed
+	// over.
+	err := s.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Failback(nil)
+	if err == nil {
+		t.Fatal("expected failing back to an unavailable primary storage to fail")
+	}
+	primary.setFail(false)
+	err = s.Failback(nil)
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+	if !s.mirror.FailedOver() {
+		t.Fatal("expected the mirror to stay failed over")
+	}
+
+	// Resynchronize the primary storage.
+	for key, value := range secondary.strings {
+		primary.strings[key] = value
+	}
+	for key, l := range secondary.lists {
+		primary.lists[key] = append([]string(nil), l...)
+	}
+	for key, set := range secondary.sets {
+		primary.sets[key] = map[string]bool{}
+		for member := range set {
+			primary.sets[key][member] = true
+		}
+	}
+
+	err = s.Failback(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.mirror.FailedOver() {
+		t.Fatal("expected the mirror to fail back")
+	}
+	if n := publisher.count("Mirror.FailedBack"); n != 1 {
+		t.Fatalf("expected failback to be counted once, got %f", n)
+	}
+
+	// Mutations are applied to both storages again after failing back.
+	err = s.Create(nil, newTestEvent(t, "2", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := primary.strings[s.scopeOf("").eventKey("2")]; !ok {
+		t.Fatal("expected event 2 to be stored in the primary storage")
+	}
+	keys, err := s.CompareMirror(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no diverged keys, got %#v", keys)
+	}
+}
+
+func Test_Service_Mirror_Compare(t *testing.T) {
+	secondary := newMemoryStorage()
+	s, publisher, diverged, _ := newTestMirrorService(t, newMemoryStorage(), secondary)
+
+	for _, eventID := range []string{"1", "2"} {
+		err := s.Create(nil, newTestEvent(t, eventID, "payload"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := s.CompareMirror(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no diverged keys, got %#v", keys)
+	}
+
+	// Diverge the payload of one event and the queue of the namespace.
+	sc := s.scopeOf("")
+	err = secondary.Set(sc.eventKey("1"), "diverged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = secondary.PopFromList(sc.namespaceKey(s.namespaceFromLabels("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err = s.CompareMirror(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{sc.namespaceKey(s.namespaceFromLabels("a")), sc.eventKey("1")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected diverged keys %#v, got %#v", expected, keys)
+	}
+	if !reflect.DeepEqual(*diverged, expected) {
+		t.Fatalf("expected notified keys %#v, got %#v", expected, *diverged)
+	}
+	if n := publisher.count("Mirror.Diverged"); n != 2 {
+		t.Fatalf("expected divergence to be counted twice, got %f", n)
+	}
+}
@@ -0,0 +1,350 @@
+package event
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/the-anna-project/context"
+	"github.com/the-anna-project/instrumentor"
+	"github.com/the-anna-project/storage"
+)
+
+// Option configures the event created by NewEvent.
+type Option func(config *Config) error
+
+// WithClock configures the clock providing the creation time of the event in
+// case WithCreated is not given.
+func WithClock(clock Clock) Option {
+	return func(config *Config) error {
+		config.Clock = clock
+		return nil
+	}
+}
+
+// WithCodec configures the codec the event is stored with. See Config.Codec.
+func WithCodec(codec Codec) Option {
+	return func(config *Config) error {
+		if codec == nil {
+			return maskAnyf(invalidConfigError, "codec must not be empty")
+		}
+		config.Codec = codec
+		return nil
+	}
+}
+
+// WithCreated configures the creation time of the event.
+func WithCreated(created time.Time) Option {
+	return func(config *Config) error {
+		config.Created = created
+		return nil
+	}
+}
+
+// WithHeaders configures the headers of the event.
+func WithHeaders(headers map[string]string) Option {
+	return func(config *Config) error {
+		config.Headers = headers
+		return nil
+	}
+}
+
+// WithID configures the ID of the event.
+func WithID(id string) Option {
+	return func(config *Config) error {
+		config.ID = id
+		return nil
+	}
+}
+
+// WithIDGenerator configures the ID generator providing the ID of the event in
+// case WithID is not given.
+func WithIDGenerator(idGenerator IDGenerator) Option {
+	return func(config *Config) error {
+		config.IDGenerator = idGenerator
+		return nil
+	}
+}
+
+// WithPayload configures the payload of the event.
+func WithPayload(payload string) Option {
+	return func(config *Config) error {
+		config.Payload = payload
+		return nil
+	}
+}
+
+// WithPayloadBytes configures the binary payload of the event.
+func WithPayloadBytes(payload []byte) Option {
+	return func(config *Config) error {
+		config.PayloadBytes = payload
+		return nil
+	}
+}
+
+// WithType configures the type and version of the event. See Config.Type and
+// Config.Version.
+func WithType(eventType string, version int) Option {
+	return func(config *Config) error {
+		config.Type = eventType
+		config.Version = version
+		return nil
+	}
+}
+
+// NewEvent creates a new event configured by the given options. Other than
+// DefaultConfig, NewEvent returns an error instead of panicking. The system
+// clock and a shared ID generator are used unless WithClock or WithIDGenerator
+// are given.
+func NewEvent(options ...Option) (Event, error) {
+	var config Config
+	for _, o := range options {
+		err := o(&config)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	if config.Clock == nil {
+		config.Clock = NewClock()
+	}
+	if config.IDGenerator == nil {
+		var err error
+		config.IDGenerator, err = defaultIDGenerator()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	newEvent, err := New(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newEvent, nil
+}
+
+// SignalOption configures the signal created by NewSignalWithOptions.
+type SignalOption func(config *SignalConfig) error
+
+// WithSignalArguments configures the arguments of the signal.
+func WithSignalArguments(arguments ...reflect.Value) SignalOption {
+	return func(config *SignalConfig) error {
+		config.Arguments = arguments
+		return nil
+	}
+}
+
+// WithSignalClock configures the clock providing the creation time of the
+// signal in case WithSignalCreated is not given.
+func WithSignalClock(clock Clock) SignalOption {
+	return func(config *SignalConfig) error {
+		config.Clock = clock
+		return nil
+	}
+}
+
+// WithSignalContext configures the context of the signal.
+func WithSignalContext(ctx context.Context) SignalOption {
+	return func(config *SignalConfig) error {
+		config.Context = ctx
+		return nil
+	}
+}
+
+// WithSignalCreated configures the creation time of the signal.
+func WithSignalCreated(created time.Time) SignalOption {
+	return func(config *SignalConfig) error {
+		config.Created = created
+		return nil
+	}
+}
+
+// WithSignalHeaders configures the headers of the signal.
+func WithSignalHeaders(headers map[string]string) SignalOption {
+	return func(config *SignalConfig) error {
+		config.Headers = headers
+		return nil
+	}
+}
+
+// WithSignalID configures the ID of the signal.
+func WithSignalID(id string) SignalOption {
+	return func(config *SignalConfig) error {
+		config.ID = id
+		return nil
+	}
+}
+
+// WithSignalIDGenerator configures the ID generator providing the ID of the
+// signal in case WithSignalID is not given.
+func WithSignalIDGenerator(idGenerator IDGenerator) SignalOption {
+	return func(config *SignalConfig) error {
+		config.IDGenerator = idGenerator
+		return nil
+	}
+}
+
+// NewSignalWithOptions creates a new signal configured by the given options.
+// Other than DefaultSignalConfig, NewSignalWithOptions returns an error instead
+// of panicking. The system clock and a shared ID generator are used unless
+// WithSignalClock or WithSignalIDGenerator are given.
+func NewSignalWithOptions(options ...SignalOption) (Signal, error) {
+	var config SignalConfig
+	for _, o := range options {
+		err := o(&config)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	if config.Clock == nil {
+		config.Clock = NewClock()
+	}
+	if config.IDGenerator == nil {
+		var err error
+		config.IDGenerator, err = defaultIDGenerator()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	newSignal, err := NewSignal(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newSignal, nil
+}
+
+// ServiceOption configures the service created by NewServiceWithOptions.
+type ServiceOption func(config *ServiceConfig) error
+
+// WithBackoffService configures the backoff service of the service.
+func WithBackoffService(backoffService func() Backoff) ServiceOption {
+	return func(config *ServiceConfig) error {
+		config.BackoffService = backoffService
+		return nil
+	}
+}
+
+// WithInstrumentorCollection configures the instrumentor collection of the
+// service.
+func WithInstrumentorCollection(instrumentorCollection *instrumentor.Collection) ServiceOption {
+	return func(config *ServiceConfig) error {
+		config.InstrumentorCollection = instrumentorCollection
+		return nil
+	}
+}
+
+// WithKeyPrefix configures the key prefix of the service.
+func WithKeyPrefix(keyPrefix string) ServiceOption {
+	return func(config *ServiceConfig) error {
+		config.KeyPrefix = keyPrefix
+		return nil
+	}
+}
+
+// WithKind configures the kind of the service.
+func WithKind(kind string) ServiceOption {
+	return func(config *ServiceConfig) error {
+		config.Kind = kind
+		return nil
+	}
+}
+
+// WithServiceConfig configures any other setting of the service by modifying
+// the configuration directly.
+func WithServiceConfig(f func(config *ServiceConfig)) ServiceOption {
+	return func(config *ServiceConfig) error {
+		f(config)
+		return nil
+	}
+}
+
+// WithStorageCollection configures the storage collection of the service.
+func WithStorageCollection(storageCollection *storage.Collection) ServiceOption {
+	return func(config *ServiceConfig) error {
+		config.StorageCollection = storageCollection
+		return nil
+	}
+}
+
+// NewServiceWithOptions creates a new service configured by the given options.
+// Settings not configured keep the defaults of DefaultServiceConfig. Other
+// than DefaultServiceConfig, NewServiceWithOptions returns an error instead of
+// panicking and only creates the dependencies that are not configured.
+func NewServiceWithOptions(options ...ServiceOption) (Service, error) {
+	config := defaultServiceSettings()
+	for _, o := range options {
+		err := o(&config)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	err := config.defaultDependencies()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	newService, err := NewService(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newService, nil
+}
+
+// CollectionOption configures the collection created by
+// NewCollectionWithOptions.
+type CollectionOption func(config *CollectionConfig) error
+
+// WithCollectionKeyPrefix configures the key prefix of the collection.
+func WithCollectionKeyPrefix(keyPrefix string) CollectionOption {
+	return func(config *CollectionConfig) error {
+		config.KeyPrefix = keyPrefix
+		return nil
+	}
+}
+
+// WithCollectionStorageCollection configures the storage collection of the
+// collection.
+func WithCollectionStorageCollection(storageCollection *storage.Collection) CollectionOption {
+	return func(config *CollectionConfig) error {
+		config.StorageCollection = storageCollection
+		return nil
+	}
+}
+
+// NewCollectionWithOptions creates a new collection configured by the given
+// options. Other than DefaultCollectionConfig, NewCollectionWithOptions
+// returns an error instead of panicking and only creates a storage collection
+// in case WithCollectionStorageCollection is not given.
+func NewCollectionWithOptions(options ...CollectionOption) (*Collection, error) {
+	config := CollectionConfig{
+		// Settings.
+		KeyPrefix: KeyPrefixDefault,
+	}
+	for _, o := range options {
+		err := o(&config)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	if config.StorageCollection == nil {
+		var err error
+		storageConfig := storage.DefaultCollectionConfig()
+		config.StorageCollection, err = storage.NewCollection(storageConfig)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	newCollection, err := NewCollection(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return newCollection, nil
+}
@@ -0,0 +1,175 @@
+package event
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/the-anna-project/storage"
+)
+
+func Test_DefaultConfig_Filled(t *testing.T) {
+	config := DefaultConfig()
+	if config.ID == "" || config.Created.IsZero() {
+		t.Fatalf("expected ID and creation time to be filled, got %q and %s", config.ID, config.Created)
+	}
+	if DefaultConfig().ID == config.ID {
+		t.Fatal("expected every default configuration to carry a new ID")
+	}
+
+	signalConfig := DefaultSignalConfig()
+	if signalConfig.ID == "" || signalConfig.Created.IsZero() {
+		t.Fatalf("expected ID and creation time to be filled, got %q and %s", signalConfig.ID, signalConfig.Created)
+	}
+}
+
+func Test_NewEvent(t *testing.T) {
+	now := time.Unix(42, 0)
+	e, err := NewEvent(
+		WithClock(NewFakeClock(now)),
+		WithCodec(NewGobCodec()),
+		WithHeaders(map[string]string{HeaderSource: "test"}),
+		WithIDGenerator(NewFakeIDGenerator("id")),
+		WithPayload("payload"),
+		WithType("test", 2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() != "id-1" || !e.Created().Equal(now) {
+		t.Fatalf("expected event id-1 created at %s, got %s created at %s", now, e.ID(), e.Created())
+	}
+	expected := map[string]string{HeaderSchemaVersion: "2", HeaderSource: "test", HeaderType: "test"}
+	if !reflect.DeepEqual(e.Headers(), expected) {
+		t.Fatalf("expected headers %#v, got %#v", expected, e.Headers())
+	}
+	if e.Payload() != "payload" {
+		t.Fatalf("expected payload, got %q", e.Payload())
+	}
+
+	// Explicit settings take precedence over the clock and ID generator.
+	e, err = NewEvent(
+		WithClock(NewFakeClock(now)),
+		WithCreated(time.Unix(1, 0)),
+		WithID("1"),
+		WithIDGenerator(NewFakeIDGenerator("id")),
+		WithPayloadBytes([]byte{0, 1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() != "1" || !e.Created().Equal(time.Unix(1, 0)) || !reflect.DeepEqual(e.PayloadBytes(), []byte{0, 1}) {
+		t.Fatalf("expected event 1 created at %s, got %s created at %s", time.Unix(1, 0), e.ID(), e.Created())
+	}
+
+	// The system clock and the shared ID generator are used by default.
+	e, err = NewEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() == "" || e.Created().IsZero() {
+		t.Fatalf("expected ID and creation time to be generated, got %q and %s", e.ID(), e.Created())
+	}
+
+	_, err = NewEvent(WithCodec(nil))
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+	_, err = NewEvent(WithType("test", -1))
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+}
+
+func Test_NewSignalWithOptions(t *testing.T) {
+	now := time.Unix(42, 0)
+	ctx := &testContext{values: map[string]string{"key": "value"}}
+	s, err := NewSignalWithOptions(
+		WithSignalArguments(),
+		WithSignalClock(NewFakeClock(now)),
+		WithSignalContext(ctx),
+		WithSignalHeaders(map[string]string{HeaderType: "signal"}),
+		WithSignalIDGenerator(NewFakeIDGenerator("id")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID() != "id-1" || !s.Created().Equal(now) || s.Context() != ctx {
+		t.Fatalf("expected signal id-1 created at %s, got %s created at %s", now, s.ID(), s.Created())
+	}
+	if s.Headers()[HeaderType] != "signal" {
+		t.Fatalf("expected signal header, got %#v", s.Headers())
+	}
+
+	s, err = NewSignalWithOptions(
+		WithSignalContext(ctx),
+		WithSignalCreated(time.Unix(1, 0)),
+		WithSignalID("1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID() != "1" || !s.Created().Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected signal 1 created at %s, got %s created at %s", time.Unix(1, 0), s.ID(), s.Created())
+	}
+
+	_, err = NewSignalWithOptions()
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+}
+
+func Test_NewServiceWithOptions(t *testing.T) {
+	m := newMemoryStorage()
+	newService, err := NewServiceWithOptions(
+		WithKeyPrefix("prefix"),
+		WithKind(KindNetwork),
+		WithServiceConfig(func(config *ServiceConfig) {
+			config.IDGenerator = NewFakeIDGenerator("id")
+		}),
+		WithStorageCollection(&storage.Collection{Event: m}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newService.Boot()
+
+	err = newService.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range m.strings {
+		if !strings.HasPrefix(key, "prefix:kind:"+KindNetwork+":") {
+			t.Fatalf("expected key %s to be prefixed", key)
+		}
+	}
+
+	_, err = NewServiceWithOptions(WithStorageCollection(&storage.Collection{Event: m}))
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error for missing kind, got %#v", err)
+	}
+}
+
+func Test_NewCollectionWithOptions(t *testing.T) {
+	c, err := NewCollectionWithOptions(
+		WithCollectionKeyPrefix("prefix"),
+		WithCollectionStorageCollection(&storage.Collection{Event: newMemoryStorage()}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Activator.(*service).kind != KindActivator || c.Network.(*service).kind != KindNetwork {
+		t.Fatal("expected activator and network service")
+	}
+	for _, s := range []Service{c.Activator, c.Network} {
+		if s.(*service).keyPrefix != "prefix" {
+			t.Fatalf("expected key prefix prefix, got %s", s.(*service).keyPrefix)
+		}
+	}
+
+	_, err = NewCollectionWithOptions(WithCollectionKeyPrefix(""))
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+}
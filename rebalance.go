@@ -0,0 +1,212 @@
+package event
+
+import (
+	"github.com/the-anna-project/context"
+	"github.com/the-anna-project/storage"
+)
+
+func (s *service) Rebalance(ctx context.Context, retired ...*storage.Collection) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, name := range s.shards.All() {
+		err := s.rebalanceShard(s.shards.Shard(name), name)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	// Retired shards are not part of the ring anymore. Their names never match
+	// any owner, so all of their namespaces are moved.
+	for _, c := range retired {
+		if c == nil {
+			return maskAnyf(invalidExecutionError, "retired storage collection must not be empty")
+		}
+		err := s.rebalanceShard(c.Event, "")
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// rebalanceShard moves all namespaces and stored bytes counters of all tenants
+// of the given shard that are not owned by it to their owning shards.
+func (s *service) rebalanceShard(shard Storage, name string) error {
+	scopes, err := s.scopes(shard)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, sc := range scopes {
+		err := s.moveStoredBytes(shard, name, sc)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		namespaces, err := shard.GetAllFromSet(sc.tableKey())
+		if err != nil {
+			return maskAny(err)
+		}
+
+		for _, namespace := range namespaces {
+			owner := s.shards.Owner(sc.namespaceKey(namespace))
+			if owner == name {
+				continue
+			}
+
+			err := s.moveNamespace(shard, s.shards.Shard(owner), sc, namespace)
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// moveNamespace moves the queue of the given namespace together with the
+// payloads of its events from the source to the target shard. The target shard
+// owns the namespace already, so events might be published to it while the
+// namespace is moved. The moved events are older and are thus appended to the
+// end of the target's queue. Storages not implementing ListAppender rebuild the
+// queue to do so, which is why this process does not publish any event while a
+// namespace is moved. Events that are consumed from the source while the
+// namespace is moved might be delivered twice, so rebalancing should happen
+// while the affected namespaces are quiet. Quarantined events, the compaction
+// keys of queued events and the times retention first saw them are moved as
+// well.
+func (s *service) moveNamespace(source, target Storage, sc scope, namespace string) error {
+	s.moveMutex.Lock()
+	defer s.moveMutex.Unlock()
+
+	eventIDs, err := source.GetAllFromList(sc.namespaceKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	moved, err := s.movePayloads(source, target, sc, eventIDs)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = s.moveCompaction(source, target, sc, moved)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = appendMany(target, sc.namespaceKey(namespace), moved)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	quarantined, err := source.GetAllFromList(sc.quarantineKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	movedQuarantined, err := s.movePayloads(source, target, sc, quarantined)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = appendMany(target, sc.quarantineKey(namespace), movedQuarantined)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = s.register(target, sc, namespace)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = source.Remove(sc.namespaceKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	err = source.Remove(sc.quarantineKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, eventID := range append(eventIDs, quarantined...) {
+		err := s.remove(source, sc, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+	err = source.RemoveFromSet(sc.tableKey(), namespace)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// movePayloads copies the payloads of the events with the given IDs from the
+// source to the target shard and returns the IDs of the copied events in the
+// given order. Events already deleted are skipped.
+func (s *service) movePayloads(source, target Storage, sc scope, eventIDs []string) ([]string, error) {
+	var moved []string
+	for _, eventID := range eventIDs {
+		ok, err := source.Exists(sc.eventKey(eventID))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if !ok {
+			// The event was already deleted. There is nothing to move.
+			continue
+		}
+		payload, err := source.Get(sc.eventKey(eventID))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		payload, err = s.load(source, sc, eventID, payload)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		err = s.overwrite(target, sc, eventID, []byte(payload))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		err = s.moveFirstSeen(source, target, sc, eventID)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		moved = append(moved, eventID)
+	}
+
+	return moved, nil
+}
+
+// moveStoredBytes moves the stored bytes counter of the scope's tenant from the
+// given shard to the shard owning it, in case the given shard does not own it.
+// The counter is added to the one of the owning shard, which counts the
+// payloads stored since the owner changed. Payloads removed from the given
+// shard while the counter is moved are not accounted for, which is why
+// rebalancing should happen while the affected tenants are quiet.
+func (s *service) moveStoredBytes(source Storage, name string, sc scope) error {
+	owner := s.shards.Owner(sc.storedBytesKey())
+	if owner == name {
+		return nil
+	}
+
+	ok, err := source.Exists(sc.storedBytesKey())
+	if err != nil {
+		return maskAny(err)
+	}
+	if !ok {
+		return nil
+	}
+	n, err := incrementBy(source, sc.storedBytesKey(), 0)
+	if err != nil {
+		return maskAny(err)
+	}
+	_, err = incrementBy(s.shards.Shard(owner), sc.storedBytesKey(), n)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = source.Remove(sc.storedBytesKey())
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
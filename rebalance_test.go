@@ -0,0 +1,243 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/the-anna-project/storage"
+)
+
+func Test_Service_Rebalance_QueueOrder(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		source := newMemoryStorage()
+		var target storage.Service = newMemoryStorage()
+		if bulk {
+			target = bulkStorage{newMemoryStorage()}
+		}
+
+		old := newTestService(t, nil, func(config *ServiceConfig) {
+			config.StorageCollection = nil
+			config.StorageShards = map[string]*storage.Collection{
+				"a": {Event: source},
+			}
+		})
+		s := newTestService(t, nil, func(config *ServiceConfig) {
+			config.StorageCollection = nil
+			config.StorageShards = map[string]*storage.Collection{
+				"a": {Event: source},
+				"b": {Event: target},
+			}
+		})
+
+		// Find labels of a namespace the added shard owns.
+		var label string
+		for i := 0; ; i++ {
+			label = fmt.Sprintf("label%d", i)
+			if s.shards.Owner(s.scopeOf("").namespaceKey(s.namespaceFromLabels(label))) == "b" {
+				break
+			}
+		}
+
+		for _, eventID := range []string{"1", "2"} {
+			err := old.Create(nil, newTestEvent(t, eventID, "payload"), label)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The event published to the owning shard before the namespace is moved is
+		// newer than the events to move.
+		err := s.Create(nil, newTestEvent(t, "3", "payload"), label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Rebalance(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, eventID := range []string{"1", "2", "3"} {
+			e, err := s.Search(nil, label)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.ID() != eventID {
+				t.Fatalf("bulk %t: expected event %s, got %s", bulk, eventID, e.ID())
+			}
+		}
+	}
+}
+
+func Test_Service_Rebalance_Compaction(t *testing.T) {
+	source := newMemoryStorage()
+	target := newMemoryStorage()
+	shards := func(config *ServiceConfig) {
+		config.CompactionMode = CompactionReplace
+		config.StorageCollection = nil
+		config.StorageShards = map[string]*storage.Collection{
+			"a": {Event: source},
+		}
+	}
+	old := newTestService(t, nil, shards)
+	s := newTestService(t, nil, func(config *ServiceConfig) {
+		shards(config)
+		config.StorageShards["b"] = &storage.Collection{Event: target}
+	})
+
+	var label string
+	for i := 0; ; i++ {
+		label = fmt.Sprintf("label%d", i)
+		if s.shards.Owner(s.scopeOf("").namespaceKey(s.namespaceFromLabels(label))) == "b" {
+			break
+		}
+	}
+
+	err := old.Create(nil, newCompactionTestEvent(t, "1", "old", "k"), label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Rebalance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The moved event keeps its compaction key, so it is replaced.
+	err = s.Create(nil, newCompactionTestEvent(t, "2", "new", "k"), label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.SearchAll(nil, label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID() != "1" || events[0].Payload() != "new" {
+		t.Fatalf("expected event 1 replaced by payload new, got %d events", len(events))
+	}
+}
+
+func Test_Service_Rebalance_Quota(t *testing.T) {
+	source := newMemoryStorage()
+	target := newMemoryStorage()
+	shards := func(config *ServiceConfig) {
+		config.StorageCollection = nil
+		config.StorageShards = map[string]*storage.Collection{
+			"a": {Event: source},
+		}
+	}
+	grown := func(config *ServiceConfig) {
+		shards(config)
+		config.StorageShards["b"] = &storage.Collection{Event: target}
+	}
+
+	// Find a tenant whose stored bytes the added shard owns.
+	probe := newTestService(t, nil, grown)
+	var tenant string
+	for i := 0; ; i++ {
+		tenant = fmt.Sprintf("tenant%d", i)
+		if probe.shards.Owner(probe.scopeOf(tenant).storedBytesKey()) == "b" {
+			break
+		}
+	}
+	sc := probe.scopeOf(tenant)
+
+	quota := func(config *ServiceConfig) {
+		config.Tenant = tenant
+		config.TenantQuota = Quota{MaxStoredBytes: 1000}
+	}
+	old := newTestService(t, nil, func(config *ServiceConfig) {
+		shards(config)
+		quota(config)
+	})
+	s := newTestService(t, nil, func(config *ServiceConfig) {
+		grown(config)
+		quota(config)
+	})
+
+	err := old.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := source.strings[sc.storedBytesKey()]
+	if stored == "" || stored == "0" {
+		t.Fatalf("expected stored bytes to be counted, got %q", stored)
+	}
+
+	err = s.Rebalance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owning shard continues counting the stored bytes of the tenant, and the
+	// counter does not leak on the shard it was moved from.
+	if target.strings[sc.storedBytesKey()] != stored {
+		t.Fatalf("expected %s stored bytes after rebalancing, got %q", stored, target.strings[sc.storedBytesKey()])
+	}
+	if _, ok := source.strings[sc.storedBytesKey()]; ok {
+		t.Fatal("expected stored bytes to be removed from the source shard")
+	}
+
+	_, err = s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.strings[sc.storedBytesKey()] != "0" {
+		t.Fatalf("expected no stored bytes after deleting, got %q", target.strings[sc.storedBytesKey()])
+	}
+}
+
+func Test_Service_Rebalance_FirstSeen(t *testing.T) {
+	source := newMemoryStorage()
+	target := newMemoryStorage()
+	clock := NewFakeClock(time.Unix(0, 0))
+	shards := func(config *ServiceConfig) {
+		config.Clock = clock
+		config.RetentionPolicies = []RetentionPolicy{{Pattern: "*", MaxAge: time.Hour}}
+		config.StorageCollection = nil
+		config.StorageShards = map[string]*storage.Collection{
+			"a": {Event: source},
+		}
+	}
+	old := newTestService(t, nil, shards)
+	s := newTestService(t, nil, func(config *ServiceConfig) {
+		shards(config)
+		config.StorageShards["b"] = &storage.Collection{Event: target}
+	})
+	sc := s.scopeOf("")
+
+	var namespace string
+	for i := 0; ; i++ {
+		namespace = s.namespaceFromLabels(fmt.Sprintf("label%d", i))
+		if s.shards.Owner(sc.namespaceKey(namespace)) == "b" {
+			break
+		}
+	}
+
+	// Events stored before events carried their creation time lack it.
+	source.lists[sc.namespaceKey(namespace)] = []string{"legacy"}
+	source.sets[sc.tableKey()] = map[string]bool{namespace: true}
+	source.strings[sc.eventKey("legacy")] = "{}"
+	err := old.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	err = s.Rebalance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The moved event keeps its age, so it is evicted.
+	err = s.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(target.lists[sc.namespaceKey(namespace)]) != 0 {
+		t.Fatal("expected legacy event to be evicted")
+	}
+}
@@ -0,0 +1,92 @@
+package event
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// NewSchemaRegistry creates a new empty schema registry.
+func NewSchemaRegistry() SchemaRegistry {
+	newRegistry := &schemaRegistry{
+		// Internals.
+		labels: map[string]*gojsonschema.Schema{},
+		mutex:  sync.RWMutex{},
+		types:  map[string]*gojsonschema.Schema{},
+	}
+
+	return newRegistry
+}
+
+type schemaRegistry struct {
+	// Internals.
+	labels map[string]*gojsonschema.Schema
+	mutex  sync.RWMutex
+	types  map[string]*gojsonschema.Schema
+}
+
+func (r *schemaRegistry) RegisterLabels(schema string, labels ...string) error {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return maskAnyf(invalidConfigError, "schema must be valid: %s", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.labels[labelsKey(labels)] = s
+
+	return nil
+}
+
+func (r *schemaRegistry) RegisterType(eventType string, schema string) error {
+	if eventType == "" {
+		return maskAnyf(invalidConfigError, "event type must not be empty")
+	}
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return maskAnyf(invalidConfigError, "schema must be valid: %s", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.types[eventType] = s
+
+	return nil
+}
+
+func (r *schemaRegistry) Validate(event Event, labels ...string) error {
+	r.mutex.RLock()
+	s, ok := r.types[event.Headers()[HeaderType]]
+	if !ok {
+		s, ok = r.labels[labelsKey(labels)]
+	}
+	r.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	result, err := s.Validate(gojsonschema.NewBytesLoader(event.PayloadBytes()))
+	if err != nil {
+		return maskAnyf(invalidPayloadError, "payload of event %s must be JSON: %s", event.ID(), err)
+	}
+	if !result.Valid() {
+		var reasons []string
+		for _, e := range result.Errors() {
+			reasons = append(reasons, e.String())
+		}
+		return maskAnyf(invalidPayloadError, "payload of event %s must match schema: %s", event.ID(), strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// labelsKey returns the key identifying the given label set, regardless of the
+// order of its labels.
+func labelsKey(labels []string) string {
+	sorted := append([]string(nil), labels...)
+	sort.Strings(sorted)
+
+	return strings.Join(sorted, ",")
+}
@@ -0,0 +1,126 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {"name": {"type": "string"}},
+	"required": ["name"]
+}`
+
+func newTestTypedPayloadEvent(t *testing.T, eventID, eventType, payload string) Event {
+	var headers map[string]string
+	if eventType != "" {
+		headers = map[string]string{HeaderType: eventType}
+	}
+	e, err := NewEvent(WithHeaders(headers), WithID(eventID), WithPayload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func Test_SchemaRegistry_Register(t *testing.T) {
+	r := NewSchemaRegistry()
+
+	err := r.RegisterLabels("{", "a")
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+	err = r.RegisterType("test", "{")
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+	err = r.RegisterType("", testSchema)
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+}
+
+func Test_SchemaRegistry_Validate(t *testing.T) {
+	r := NewSchemaRegistry()
+	err := r.RegisterLabels(testSchema, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.RegisterType("number", `{"type": "number"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Event   Event
+		Labels  []string
+		Invalid bool
+	}{
+		// The label schema applies regardless of the order of the labels.
+		{Event: newTestTypedPayloadEvent(t, "1", "", `{"name": "x"}`), Labels: []string{"b", "a"}, Invalid: false},
+		{Event: newTestTypedPayloadEvent(t, "2", "", `{"name": 1}`), Labels: []string{"a", "b"}, Invalid: true},
+		{Event: newTestTypedPayloadEvent(t, "3", "", `{}`), Labels: []string{"a", "b"}, Invalid: true},
+		{Event: newTestTypedPayloadEvent(t, "4", "", `not json`), Labels: []string{"a", "b"}, Invalid: true},
+		// The type schema takes precedence over the label schema.
+		{Event: newTestTypedPayloadEvent(t, "5", "number", `1`), Labels: []string{"a", "b"}, Invalid: false},
+		{Event: newTestTypedPayloadEvent(t, "6", "number", `{"name": "x"}`), Labels: []string{"a", "b"}, Invalid: true},
+		// Events without schema are not validated.
+		{Event: newTestTypedPayloadEvent(t, "7", "other", `not json`), Labels: []string{"c"}, Invalid: false},
+	}
+
+	for _, tc := range testCases {
+		err := r.Validate(tc.Event, tc.Labels...)
+		if tc.Invalid && !IsInvalidPayload(err) {
+			t.Fatalf("event %s: expected invalid payload error, got %#v", tc.Event.ID(), err)
+		} else if !tc.Invalid && err != nil {
+			t.Fatalf("event %s: expected no error, got %#v", tc.Event.ID(), err)
+		}
+	}
+}
+
+func Test_Service_SchemaRegistry(t *testing.T) {
+	r := NewSchemaRegistry()
+	err := r.RegisterLabels(testSchema, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMemoryStorage()
+	s := newTestService(t, m, func(config *ServiceConfig) {
+		config.SchemaRegistry = r
+	})
+	sc := s.scopeOf("")
+
+	create(t, s, newTestTypedPayloadEvent(t, "1", "", `{"name": "x"}`))
+	err = s.Create(nil, newTestTypedPayloadEvent(t, "2", "", `{}`), "a")
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error, got %#v", err)
+	}
+	err = s.CreateAsync(nil, newTestTypedPayloadEvent(t, "2", "", `{}`), "a").Err()
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error, got %#v", err)
+	}
+
+	// A single invalid event refuses the whole write, which leaves the queued
+	// events untouched.
+	err = s.WriteAll(nil, []Event{
+		newTestTypedPayloadEvent(t, "3", "", `{"name": "y"}`),
+		newTestTypedPayloadEvent(t, "4", "", `{"name": 1}`),
+	}, "a")
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error, got %#v", err)
+	}
+	l := m.lists[sc.namespaceKey("a")]
+	if !reflect.DeepEqual(l, []string{"1"}) {
+		t.Fatalf("expected queue [1], got %v", l)
+	}
+
+	err = s.WriteAll(nil, []Event{newTestTypedPayloadEvent(t, "3", "", `{"name": "y"}`)}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = m.lists[sc.namespaceKey("a")]
+	if !reflect.DeepEqual(l, []string{"3"}) {
+		t.Fatalf("expected queue [3], got %v", l)
+	}
+}
@@ -0,0 +1,376 @@
+package event
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// retentionPolicy returns the first retention policy matching the given
+// namespace.
+func (s *service) retentionPolicy(namespace string) (RetentionPolicy, bool) {
+	for _, p := range s.retentionPolicies {
+		ok, err := path.Match(p.Pattern, namespace)
+		if err != nil {
+			// The patterns are validated when creating the service.
+			continue
+		}
+		if ok {
+			return p, true
+		}
+	}
+
+	return RetentionPolicy{}, false
+}
+
+// enforceRetention applies the configured retention policies once to all
+// namespaces of all tenants of all shards. Failures are counted and do not keep
+// retention from being enforced for the other namespaces. The first failure is
+// returned.
+func (s *service) enforceRetention() error {
+	var firstErr error
+	fail := func(err error) {
+		s.count("Retention.Failed")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, name := range s.shards.All() {
+		shard := s.shards.Shard(name)
+
+		scopes, err := s.scopes(shard)
+		if err != nil {
+			fail(maskAny(err))
+			continue
+		}
+
+		for _, sc := range scopes {
+			namespaces, err := shard.GetAllFromSet(sc.tableKey())
+			if err != nil {
+				fail(maskAny(err))
+				continue
+			}
+
+			for _, namespace := range namespaces {
+				p, ok := s.retentionPolicy(namespace)
+				if !ok {
+					continue
+				}
+				err := s.enforceRetentionPolicy(shard, sc, namespace, p)
+				if err != nil {
+					fail(maskAny(err))
+				}
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return maskAny(firstErr)
+	}
+
+	return nil
+}
+
+// enforceRetentionPolicy evicts the oldest events of the given namespace until
+// the namespace satisfies the given policy. Evicted events are handed to the
+// configured archiver before they are removed. In case archiving fails, no
+// event is evicted. Events are only decoded in case the policy limits their age
+// or an archiver is configured. Events whose age cannot be told, because they
+// cannot be decoded, are retained.
+func (s *service) enforceRetentionPolicy(shard Storage, sc scope, namespace string, p RetentionPolicy) error {
+	eventIDs, err := shard.GetAllFromList(sc.namespaceKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	// The storage pushes new elements to the head of a list. Walking the list
+	// forward thus visits the newest events first. The first event violating the
+	// policy marks the position from which on all older events are evicted.
+	var keep int
+	var bytes int
+	now := s.clock.Now()
+	for keep = 0; keep < len(eventIDs); keep++ {
+		if p.MaxCount > 0 && keep >= p.MaxCount {
+			break
+		}
+		if p.MaxBytes == 0 && p.MaxAge == 0 {
+			continue
+		}
+
+		rawEvent, err := s.retainedRaw(shard, sc, eventIDs[keep])
+		if IsNotFound(err) {
+			// The event was already deleted. It is retained until it is popped from
+			// the queue.
+			continue
+		} else if err != nil {
+			return maskAny(err)
+		}
+
+		if p.MaxBytes > 0 {
+			size, err := rawSize(rawEvent)
+			if err != nil {
+				return maskAny(err)
+			}
+			bytes += size
+			if bytes > p.MaxBytes {
+				break
+			}
+		}
+		if p.MaxAge > 0 {
+			e, err := s.retainedEvent(shard, sc, namespace, eventIDs[keep], rawEvent, false)
+			if err != nil {
+				s.count("Retention.Undecodable")
+				continue
+			}
+			created := e.Created()
+			if created.IsZero() {
+				created, err = s.firstSeen(shard, sc, eventIDs[keep], now)
+				if err != nil {
+					return maskAny(err)
+				}
+			}
+			if now.Sub(created) > p.MaxAge {
+				break
+			}
+		}
+	}
+
+	if keep == len(eventIDs) {
+		return nil
+	}
+
+	// The events to evict are archived oldest first. Events that cannot be
+	// archived, because they cannot be decoded, are quarantined instead and
+	// keep their stored representations.
+	archived := map[string]bool{}
+	quarantined := map[string]bool{}
+	for i := len(eventIDs) - 1; i >= keep; i-- {
+		ok, err := s.archive(shard, sc, namespace, eventIDs[i])
+		if err != nil {
+			return maskAny(err)
+		}
+		archived[eventIDs[i]] = true
+		quarantined[eventIDs[i]] = !ok
+	}
+
+	// The oldest events are evicted from the queue atomically, so that events
+	// pushed while the policy was evaluated do not push out any retained event.
+	// Consumers might have popped some of the events to evict in the meantime.
+	// The same number of the oldest retained events is then evicted instead,
+	// which is accepted in favour of not locking the namespace. They are archived
+	// before their payloads are removed.
+	evicted, err := evictFromList(shard, sc.namespaceKey(namespace), len(eventIDs)-keep)
+	if err != nil {
+		return maskAny(err)
+	}
+	for i := len(evicted) - 1; i >= 0; i-- {
+		if archived[evicted[i]] {
+			continue
+		}
+		ok, err := s.archive(shard, sc, namespace, evicted[i])
+		if err != nil {
+			return maskAny(err)
+		}
+		quarantined[evicted[i]] = !ok
+	}
+
+	ok, err := shard.Exists(sc.namespaceKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	if !ok {
+		err := shard.RemoveFromSet(sc.tableKey(), namespace)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	for _, eventID := range evicted {
+		if quarantined[eventID] {
+			continue
+		}
+		err := s.remove(shard, sc, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// archive hands the event with the given ID to the configured archiver, in
+// case there is any. Events already deleted are not archived. Events that
+// cannot be decoded cannot be archived either. They are quarantined instead, so
+// that a single bad record does not keep the namespace from being evicted. In
+// that case archive returns false, because the stored representation of the
+// event has to be kept.
+func (s *service) archive(shard Storage, sc scope, namespace string, eventID string) (bool, error) {
+	if s.archiver == nil {
+		return true, nil
+	}
+
+	rawEvent, err := s.retainedRaw(shard, sc, eventID)
+	if IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, maskAny(err)
+	}
+	e, err := s.retainedEvent(shard, sc, namespace, eventID, rawEvent, true)
+	if err != nil {
+		s.count("Retention.Undecodable")
+		qerr := s.quarantine(shard, sc, namespace, eventID, err)
+		if qerr != nil {
+			return false, maskAny(qerr)
+		}
+
+		return false, nil
+	}
+
+	err = s.archiver(namespace, e)
+	if err != nil {
+		return false, maskAny(err)
+	}
+
+	return true, nil
+}
+
+// firstSeen returns the time retention saw the event with the given ID for the
+// first time. It is the age of events lacking a creation time, like events
+// stored before events carried their creation time. The given time is recorded
+// in case the event was not seen before.
+func (s *service) firstSeen(shard Storage, sc scope, eventID string, now time.Time) (time.Time, error) {
+	ok, err := shard.Exists(sc.firstSeenKey(eventID))
+	if err != nil {
+		return time.Time{}, maskAny(err)
+	}
+	if !ok {
+		err := shard.Set(sc.firstSeenKey(eventID), now.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			return time.Time{}, maskAny(err)
+		}
+
+		return now, nil
+	}
+
+	raw, err := shard.Get(sc.firstSeenKey(eventID))
+	if err != nil {
+		return time.Time{}, maskAny(err)
+	}
+	seen, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, maskAnyf(invalidExecutionError, "first seen time of event %s must be RFC 3339: %s", eventID, err)
+	}
+
+	return seen, nil
+}
+
+// moveFirstSeen copies the time retention first saw the event with the given
+// ID from the source to the target shard, so that the age of the event is not
+// reset when its namespace is moved.
+func (s *service) moveFirstSeen(source, target Storage, sc scope, eventID string) error {
+	if len(s.retentionPolicies) == 0 {
+		return nil
+	}
+
+	ok, err := source.Exists(sc.firstSeenKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+	if !ok {
+		return nil
+	}
+	seen, err := source.Get(sc.firstSeenKey(eventID))
+	if err != nil {
+		return maskAny(err)
+	}
+	err = target.Set(sc.firstSeenKey(eventID), seen)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// retainedRaw fetches the stored value of the event with the given ID. Events
+// already deleted or dropped by compaction are not found.
+func (s *service) retainedRaw(shard Storage, sc scope, eventID string) (string, error) {
+	ok, err := shard.Exists(sc.eventKey(eventID))
+	if err != nil {
+		return "", maskAny(err)
+	}
+	if !ok {
+		return "", maskAny(notFoundError)
+	}
+	rawEvent, err := shard.Get(sc.eventKey(eventID))
+	if isNotFound(err) {
+		return "", maskAny(notFoundError)
+	} else if err != nil {
+		return "", maskAny(err)
+	}
+	if rawEvent == compactedPayload {
+		return "", maskAny(notFoundError)
+	}
+
+	return rawEvent, nil
+}
+
+// retainedEvent decodes the given stored value of the event with the given ID
+// queued within the given namespace. The event is only upcast in case upcast is
+// true.
+func (s *service) retainedEvent(shard Storage, sc scope, namespace, eventID string, rawEvent string, upcast bool) (Event, error) {
+	rawEvent, err := s.load(shard, sc, eventID, rawEvent)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if !upcast {
+		e, err := s.decodeStored(sc, namespace, eventID, []byte(rawEvent))
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return e, nil
+	}
+	e, err := s.unmarshal(sc, namespace, eventID, rawEvent)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return e, nil
+}
+
+// rawSize returns the size of the stored representation of an event given its
+// stored value, without reassembling its chunks.
+func rawSize(rawEvent string) (int, error) {
+	m, ok, err := manifest(rawEvent)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if ok {
+		return m.Size, nil
+	}
+
+	return len(rawEvent), nil
+}
+
+// retain periodically enforces the configured retention policies until the
+// service is shut down.
+func (s *service) retain() {
+	ticker := s.clock.NewTicker(s.retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closer:
+			return
+		case <-ticker.C():
+			s.instrumentor.Publisher.WrapFunc("Retention", s.enforceRetention)()
+		}
+	}
+}
+
+// redis string
+// holding the time retention first saw an event lacking a creation time
+func (c scope) firstSeenKey(eventID string) string {
+	return fmt.Sprintf("%s:seen", c.eventKey(eventID))
+}
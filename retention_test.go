@@ -0,0 +1,196 @@
+package event
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_Service_Retention_LegacyEvent(t *testing.T) {
+	m := newMemoryStorage()
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := newTestService(t, m, func(config *ServiceConfig) {
+		config.Clock = clock
+		config.RetentionPolicies = []RetentionPolicy{{Pattern: "*", MaxAge: time.Hour}}
+	})
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events stored before events carried their creation time lack it.
+	m.lists[sc.namespaceKey("a")] = []string{"legacy"}
+	m.sets[sc.tableKey()] = map[string]bool{"a": true}
+	m.strings[sc.eventKey("legacy")] = "{}"
+
+	err = s.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.lists[sc.namespaceKey("a")]) != 1 {
+		t.Fatal("expected legacy event to be retained")
+	}
+
+	clock.Advance(2 * time.Hour)
+	err = s.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.lists[sc.namespaceKey("a")]) != 0 {
+		t.Fatal("expected legacy event to be evicted")
+	}
+	for _, key := range []string{sc.eventKey("legacy"), sc.firstSeenKey("legacy")} {
+		if _, ok := m.strings[key]; ok {
+			t.Fatalf("expected key %s to be removed", key)
+		}
+	}
+}
+
+// racingStorage is a bulkStorage calling the given function right before it
+// evicts elements from a list.
+type racingStorage struct {
+	bulkStorage
+	before func()
+}
+
+func (r racingStorage) EvictFromList(key string, n int) ([]string, error) {
+	r.before()
+	return r.bulkStorage.EvictFromList(key, n)
+}
+
+func Test_Service_Retention_ConcurrentCreate(t *testing.T) {
+	m := newMemoryStorage()
+	var s *service
+	r := racingStorage{bulkStorage: bulkStorage{m}}
+	r.before = func() {
+		// An event is created while the policy is enforced.
+		err := s.Create(nil, newTestEvent(t, "4", "payload"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s = newTestService(t, r, func(config *ServiceConfig) {
+		config.RetentionPolicies = []RetentionPolicy{{Pattern: "*", MaxCount: 2}}
+	})
+	sc, err := s.scope(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create(t, s,
+		newTestEvent(t, "1", "payload"),
+		newTestEvent(t, "2", "payload"),
+		newTestEvent(t, "3", "payload"),
+	)
+
+	err = s.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the oldest event is evicted. The retained events are not pushed out by
+	// the concurrently created one.
+	l := m.lists[sc.namespaceKey("a")]
+	if !reflect.DeepEqual(l, []string{"4", "3", "2"}) {
+		t.Fatalf("expected queue [4 3 2], got %v", l)
+	}
+	if _, ok := m.strings[sc.eventKey("1")]; ok {
+		t.Fatal("expected payload of evicted event to be removed")
+	}
+	for _, eventID := range []string{"2", "3", "4"} {
+		if _, ok := m.strings[sc.eventKey(eventID)]; !ok {
+			t.Fatalf("expected payload of event %s to be retained", eventID)
+		}
+	}
+}
+
+func Test_Service_Retention_Undecodable(t *testing.T) {
+	m := newMemoryStorage()
+	var archived []string
+	s := newTestService(t, m, func(config *ServiceConfig) {
+		config.Archiver = func(namespace string, e Event) error {
+			archived = append(archived, namespace+":"+e.ID())
+			return nil
+		}
+		config.RetentionPolicies = []RetentionPolicy{{Pattern: "*", MaxCount: 1}}
+	})
+	sc := s.scopeOf("")
+
+	for _, namespace := range []string{"a", "b"} {
+		for _, eventID := range []string{"1", "2", "3"} {
+			err := s.Create(nil, newTestEvent(t, namespace+eventID, "payload"), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	m.strings[sc.eventKey("a1")] = "garbage"
+
+	// The undecodable event is quarantined instead of being archived. It neither
+	// keeps the other events of its namespace nor the other namespaces from
+	// being evicted.
+	err := s.enforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(archived)
+	expected := []string{"a:a2", "b:b1", "b:b2"}
+	if !reflect.DeepEqual(archived, expected) {
+		t.Fatalf("expected archived events %v, got %v", expected, archived)
+	}
+	for namespace, eventID := range map[string]string{"a": "a3", "b": "b3"} {
+		l := m.lists[sc.namespaceKey(namespace)]
+		if !reflect.DeepEqual(l, []string{eventID}) {
+			t.Fatalf("expected queue [%s] of namespace %s, got %v", eventID, namespace, l)
+		}
+	}
+	l := m.lists[sc.quarantineKey("a")]
+	if !reflect.DeepEqual(l, []string{"a1"}) {
+		t.Fatalf("expected quarantine [a1], got %v", l)
+	}
+	if m.strings[sc.eventKey("a1")] != "garbage" {
+		t.Fatal("expected payload of quarantined event to be kept")
+	}
+}
+
+func Test_Service_Retention_DecodeOnlyForAge(t *testing.T) {
+	testCases := []struct {
+		Policy   RetentionPolicy
+		Expected []string
+	}{
+		// Evicting by count does not need to decode any event.
+		{Policy: RetentionPolicy{Pattern: "*", MaxCount: 1}, Expected: []string{"3"}},
+		// The age of an undecodable event cannot be told, so it is retained.
+		{Policy: RetentionPolicy{Pattern: "*", MaxAge: time.Hour}, Expected: []string{"3", "2", "1"}},
+	}
+
+	for _, tc := range testCases {
+		m := newMemoryStorage()
+		clock := NewFakeClock(time.Unix(0, 0))
+		s := newTestService(t, m, func(config *ServiceConfig) {
+			config.Clock = clock
+			config.RetentionPolicies = []RetentionPolicy{tc.Policy}
+		})
+		sc := s.scopeOf("")
+
+		create(t, s,
+			newTestEvent(t, "1", "payload"),
+			newTestEvent(t, "2", "payload"),
+			newTestEvent(t, "3", "payload"),
+		)
+		for _, eventID := range []string{"1", "2", "3"} {
+			m.strings[sc.eventKey(eventID)] = "garbage"
+		}
+
+		clock.Advance(2 * time.Hour)
+		err := s.enforceRetention()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := m.lists[sc.namespaceKey("a")]
+		if !reflect.DeepEqual(l, tc.Expected) {
+			t.Fatalf("expected queue %v, got %v", tc.Expected, l)
+		}
+	}
+}
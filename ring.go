@@ -0,0 +1,82 @@
+package event
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+const (
+	// ringReplicas is the number of virtual nodes each shard occupies on the
+	// hash ring. More virtual nodes distribute namespaces more evenly.
+	ringReplicas = 128
+)
+
+// ring assigns namespaces to storage shards using consistent hashing. Adding
+// or removing a shard only moves the namespaces owned by the virtual nodes of
+// that shard.
+type ring struct {
+	hashes []uint32
+	names  []string
+	owners map[uint32]string
+	shards map[string]Storage
+}
+
+func newRing(shards map[string]Storage) *ring {
+	r := &ring{
+		hashes: nil,
+		names:  nil,
+		owners: map[uint32]string{},
+		shards: shards,
+	}
+
+	for name := range shards {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+
+	for _, name := range r.names {
+		for i := 0; i < ringReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i)))
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = name
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Sort(hashes(r.hashes))
+
+	return r
+}
+
+// All returns the names of all shards in a stable order.
+func (r *ring) All() []string {
+	return r.names
+}
+
+// Owner returns the name of the shard owning the given key.
+func (r *ring) Owner(key string) string {
+	if len(r.names) == 1 {
+		return r.names[0]
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+// Shard returns the storage of the shard with the given name.
+func (r *ring) Shard(name string) Storage {
+	return r.shards[name]
+}
+
+type hashes []uint32
+
+func (h hashes) Len() int           { return len(h) }
+func (h hashes) Less(i, j int) bool { return h[i] < h[j] }
+func (h hashes) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"strconv"
+)
+
+// migrate brings the data stored for the service's kind to the schema version
+// of the code. Every shard carries its own version marker, because shards
+// might have been added at different points in time.
+func (s *service) migrate() error {
+	for _, name := range s.shards.All() {
+		err := s.migrateShard(s.shards.Shard(name))
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// migrateShard brings the data stored in the given shard to the schema version
+// of the code. In case no version is stored yet, the current version is
+// written, because there is nothing to migrate.
+func (s *service) migrateShard(shard Storage) error {
+	ok, err := shard.Exists(s.versionKey())
+	if err != nil {
+		return maskAny(err)
+	}
+	if !ok {
+		err := shard.Set(s.versionKey(), strconv.Itoa(SchemaVersion))
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	raw, err := shard.Get(s.versionKey())
+	if err != nil {
+		return maskAny(err)
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil {
+		return maskAnyf(invalidSchemaError, "stored version must be a number, got %q", raw)
+	}
+	if version > SchemaVersion {
+		return maskAnyf(invalidSchemaError, "stored version %d is newer than %d", version, SchemaVersion)
+	}
+
+	for version < SchemaVersion {
+		migration, ok := s.migrations[version]
+		if !ok {
+			return maskAnyf(invalidSchemaError, "no migration registered for version %d", version)
+		}
+		err := migration(shard, s.keyPrefix, s.kind)
+		if err != nil {
+			return maskAny(err)
+		}
+		version++
+
+		// The version is written after every single migration, so an interrupted
+		// upgrade continues where it stopped.
+		err = shard.Set(s.versionKey(), strconv.Itoa(version))
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package event
+
+import (
+	"testing"
+)
+
+func Test_Service_Boot_Retry(t *testing.T) {
+	g := &gatedStorage{memoryStorage: newMemoryStorage()}
+	g.setFail(true)
+	s := newTestService(t, g, nil)
+
+	err := s.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if !IsUnavailable(err) {
+		t.Fatalf("expected unavailable error, got %#v", err)
+	}
+
+	// The storage recovered, so booting again brings the service up.
+	g.setFail(false)
+	s.Boot()
+	err = s.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Service_Boot_InvalidSchema(t *testing.T) {
+	m := newMemoryStorage()
+	s := newUnbootedTestService(t, m, nil)
+	err := m.Set(s.versionKey(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Boot()
+	_, err = s.ExistsAny(nil, "a")
+	if !IsInvalidSchema(err) {
+		t.Fatalf("expected invalid schema error, got %#v", err)
+	}
+
+	// Registering the missing migration and booting again brings the service
+	// up.
+	s.migrations[0] = func(storage Storage, keyPrefix, kind string) error { return nil }
+	s.Boot()
+	_, err = s.ExistsAny(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,9 +1,12 @@
 package event
 
 import (
-	"encoding/json"
+	"crypto/cipher"
 	"fmt"
+	"math/rand"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,64 +27,279 @@ const (
 	// NamespaceDefault represents the default namespace in which signals can be
 	// put that are not supposed to be queued in any custom namespace.
 	NamespaceDefault = "default"
+	// ShardDefault represents the name of the only shard of an event service
+	// that is not configured with multiple storage shards.
+	ShardDefault = "default"
 	// LabelWildcard represents a wildcard label which can be used to consume
 	// events associated with all labels using Service.Search.
 	LabelWildcard = "*"
+	// KeyPrefixDefault represents the default prefix of all storage keys managed
+	// by the event service.
+	KeyPrefixDefault = "service:event"
+	// SchemaVersion represents the version of the key schema the event service
+	// implements. It is stored per kind and verified on Service.Boot.
+	SchemaVersion = 1
+
+	// getManyConcurrency is the number of values fetched concurrently from
+	// storages not implementing MultiGetter.
+	getManyConcurrency = 16
 )
 
 // ServiceConfig represents the configuration used to create a new event
 // service.
 type ServiceConfig struct {
 	// Dependencies.
-	BackoffService         func() Backoff
+	BackoffService func() Backoff
+	// Clock provides the current time to all time based behaviour of the
+	// service, e.g. circuit breakers, retention policies and the creation time
+	// of events created without one.
+	Clock Clock
+	// IDGenerator provides the IDs of events created without ID.
+	IDGenerator            IDGenerator
 	InstrumentorCollection *instrumentor.Collection
 	StorageCollection      *storage.Collection
+	// StorageShards optionally configures the sharded service mode. Namespaces
+	// are then distributed across the given storage collections by consistent
+	// hashing and StorageCollection is not used. The keys name the shards and
+	// must stay the same across deployments, because they determine the
+	// assignment of namespaces to shards.
+	StorageShards map[string]*storage.Collection
+	// SecondaryStorageCollection optionally configures a secondary storage
+	// receiving every mutation of StorageCollection. In case StorageCollection
+	// fails a health check, the service serves all requests from the secondary
+	// storage. Mirroring cannot be combined with StorageShards. Failing over is
+	// local to the process, see Service.Failback.
+	SecondaryStorageCollection *storage.Collection
 
 	// Settings.
-	Kind string
+	// BatchSize enables the buffered producer mode in case it is greater than 0.
+	// Calls to Service.Create are then buffered and flushed in batches as soon
+	// as BatchSize events are buffered or BatchInterval passed. The flushing
+	// worker is started on Service.Boot or with the first buffered event and
+	// Service.Shutdown flushes all pending events.
+	BatchSize int
+	// BatchConcurrency is the maximum number of namespaces written concurrently
+	// while flushing a batch. The events of a single namespace are published in
+	// the order they were buffered.
+	BatchConcurrency int
+	// BatchInterval is the maximum duration events are buffered.
+	BatchInterval time.Duration
+	// BreakerFailureThreshold enables a circuit breaker around every storage in
+	// case it is greater than 0. The breaker opens after the given number of
+	// consecutive failed storage operations. While it is open, all operations
+	// fail fast with an unavailable error.
+	BreakerFailureThreshold int
+	// BreakerOpenDuration is the duration a breaker stays open before it lets
+	// probing operations through.
+	BreakerOpenDuration time.Duration
+	// BreakerProbes is the number of probing operations that have to succeed to
+	// close an open breaker again.
+	BreakerProbes int
+	// ChunkSize enables chunked storage in case it is greater than 0. Stored
+	// events exceeding ChunkSize bytes are then split into chunks of ChunkSize
+	// bytes, which are reassembled and verified against a digest when the event
+	// is read. Chunks are only cleaned up while ChunkSize is configured.
+	ChunkSize int
+	// Codec encodes stored events unless an event was created with a codec of
+	// its own. The default codec, CodecJSON, grows binary payloads by a third.
+	// See CodecGob and CodecMessagePack.
+	Codec Codec
+	// Codecs registers additional codecs stored events can be decoded with, e.g.
+	// a protobuf codec. The built-in codecs and Codec are always registered.
+	Codecs []Codec
+	// Compression optionally compresses stored events and must then be one of
+	// CompressionGzip, CompressionSnappy or CompressionZstd. Stored events are
+	// decompressed regardless of Compression, so it can be changed at any time.
+	Compression string
+	// CompressionThreshold is the size in bytes an encoded event has to exceed
+	// to be compressed.
+	CompressionThreshold int
+	// MaxDecompressedSize is the size in bytes a compressed event must not
+	// exceed once decompressed. Events exceeding it cannot be consumed, so that
+	// a single stored value cannot exhaust the memory of consumers.
+	MaxDecompressedSize int
+	// CompactionMode configures the compaction of events carrying a
+	// HeaderCompactionKey header and must be either CompactionReplace or
+	// CompactionDrop. Compaction is disabled in case CompactionMode is empty,
+	// because it costs additional storage round trips for every consumed event.
+	CompactionMode string
+	Kind           string
+	KeyPrefix      string
+	// Keyring optionally configures the encryption of stored events.
+	Keyring Keyring
+	// AcceptUnencrypted allows reading unencrypted events while a key of Keyring
+	// is active, which is required while events stored before encryption was
+	// enabled are migrated using Service.Reencrypt. Otherwise such events are
+	// considered tampered, so that encryption cannot be bypassed by writing
+	// plain events to the storage.
+	AcceptUnencrypted bool
+	// Migrations maps a stored schema version to the migration upgrading the
+	// stored data to the next schema version.
+	Migrations map[int]Migration
+	// DivergenceNotifier is called whenever a mutation could not be mirrored to
+	// the secondary storage, or Service.CompareMirror found a key holding
+	// different data in both storages, which means the storages diverged.
+	DivergenceNotifier func(key string, err error)
+	// FailoverNotifier is called once the primary storage failed
+	// HealthCheckFailures consecutive health checks and the service failed over
+	// to the secondary storage. The primary storage has to be resynchronized
+	// before the service can fail back using Service.Failback.
+	FailoverNotifier func(err error)
+	// QuarantineTampered configures Service.Search to keep tampered events in a
+	// quarantine list of their namespace instead of dropping them. Either way
+	// Service.Search returns a tampered error for them. Events failing to be
+	// loaded, decoded or upcast are quarantined regardless. Operators inspect
+	// the quarantine using Service.Quarantined and drain it using
+	// Service.ClearQuarantine.
+	QuarantineTampered bool
+	// SigningSecret optionally enables signing stored events with the given
+	// shared secret. Events whose signature does not verify, including events
+	// stored without signature, are considered tampered. Signatures cover the
+	// storage key, namespace and tenant of an event, so that signed events
+	// cannot be moved.
+	SigningSecret []byte
+	// SchemaRegistry optionally validates the payloads of events created using
+	// Service.Create, Service.CreateAsync and Service.WriteAll.
+	SchemaRegistry SchemaRegistry
+	// UpcasterRegistry optionally brings every consumed event to the latest
+	// version of its type.
+	UpcasterRegistry UpcasterRegistry
+	// HealthCheckInterval is the interval in which the primary storage is
+	// checked when SecondaryStorageCollection is configured.
+	HealthCheckInterval time.Duration
+	// HealthCheckFailures is the number of consecutive health checks the primary
+	// storage has to fail before the service fails over to the secondary
+	// storage.
+	HealthCheckFailures int
+	// Tenant is the tenant of all operations in case TenantFunc does not provide
+	// a tenant. The data of every tenant is kept under separate keys and no
+	// operation, not even a wildcard Service.Search, crosses tenant boundaries.
+	// The empty tenant uses the key layout of services without tenants.
+	Tenant string
+	// TenantFunc optionally looks up the tenant of an operation within the
+	// context given to the operation.
+	TenantFunc func(ctx context.Context) (string, error)
+	// TenantQuota limits the resources every tenant can use.
+	TenantQuota Quota
+	// TenantQuotas overwrites TenantQuota for specific tenants.
+	TenantQuotas map[string]Quota
+	// RetentionPolicies configures the retention of events per namespace. The
+	// first policy matching a namespace applies. The policies are enforced by a
+	// worker started on Service.Boot.
+	RetentionPolicies []RetentionPolicy
+	// RetentionInterval is the interval in which RetentionPolicies are enforced.
+	RetentionInterval time.Duration
+	// Archiver optionally receives every event evicted by a retention policy
+	// before it is removed. In case Archiver returns an error, the event is
+	// retained and eviction is retried with the next enforcement.
+	Archiver func(namespace string, event Event) error
 }
 
 // DefaultServiceConfig provides a default configuration to create a new event
 // service by best effort.
 func DefaultServiceConfig() ServiceConfig {
+	config := defaultServiceSettings()
+
+	err := config.defaultDependencies()
+	if err != nil {
+		panic(err)
+	}
+
+	return config
+}
+
+// defaultServiceSettings provides the default configuration of DefaultServiceConfig
+// without any dependencies.
+func defaultServiceSettings() ServiceConfig {
+	config := ServiceConfig{
+		// Dependencies.
+		BackoffService:             nil,
+		Clock:                      nil,
+		IDGenerator:                nil,
+		InstrumentorCollection:     nil,
+		StorageCollection:          nil,
+		StorageShards:              nil,
+		SecondaryStorageCollection: nil,
+
+		// Settings.
+		BatchSize:               0,
+		BatchConcurrency:        16,
+		BatchInterval:           100 * time.Millisecond,
+		BreakerFailureThreshold: 0,
+		BreakerOpenDuration:     10 * time.Second,
+		BreakerProbes:           1,
+		ChunkSize:               0,
+		Codec:                   NewJSONCodec(),
+		Codecs:                  nil,
+		Compression:             "",
+		CompressionThreshold:    1024,
+		MaxDecompressedSize:     64 * 1024 * 1024,
+		CompactionMode:          "",
+		Kind:                    "",
+		KeyPrefix:               KeyPrefixDefault,
+		Keyring:                 Keyring{},
+		AcceptUnencrypted:       false,
+		Migrations:              map[int]Migration{},
+		DivergenceNotifier:      nil,
+		FailoverNotifier:        nil,
+		QuarantineTampered:      false,
+		SigningSecret:           nil,
+		SchemaRegistry:          nil,
+		UpcasterRegistry:        nil,
+		HealthCheckInterval:     5 * time.Second,
+		HealthCheckFailures:     3,
+		Tenant:                  "",
+		TenantFunc:              nil,
+		TenantQuota:             Quota{},
+		TenantQuotas:            nil,
+		RetentionPolicies:       nil,
+		RetentionInterval:       time.Minute,
+		Archiver:                nil,
+	}
+
+	return config
+}
+
+// defaultDependencies creates the default dependencies of the configuration
+// that are not configured yet. No storage collection is created in case
+// storage shards are configured.
+func (c *ServiceConfig) defaultDependencies() error {
 	var err error
 
-	var backoffService func() Backoff
-	{
-		backoffService = func() Backoff {
+	if c.BackoffService == nil {
+		c.BackoffService = func() Backoff {
 			return &backoff.StopBackOff{}
 		}
 	}
 
-	var instrumentorCollection *instrumentor.Collection
-	{
-		instrumentorConfig := instrumentor.DefaultCollectionConfig()
-		instrumentorCollection, err = instrumentor.NewCollection(instrumentorConfig)
+	if c.Clock == nil {
+		c.Clock = NewClock()
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator, err = defaultIDGenerator()
 		if err != nil {
-			panic(err)
+			return maskAny(err)
 		}
 	}
 
-	var storageCollection *storage.Collection
-	{
-		storageConfig := storage.DefaultCollectionConfig()
-		storageCollection, err = storage.NewCollection(storageConfig)
+	if c.InstrumentorCollection == nil {
+		instrumentorConfig := instrumentor.DefaultCollectionConfig()
+		c.InstrumentorCollection, err = instrumentor.NewCollection(instrumentorConfig)
 		if err != nil {
-			panic(err)
+			return maskAny(err)
 		}
 	}
 
-	config := ServiceConfig{
-		// Dependencies.
-		BackoffService:         backoffService,
-		InstrumentorCollection: instrumentorCollection,
-		StorageCollection:      storageCollection,
-
-		// Settings.
-		Kind: "",
+	if c.StorageCollection == nil && len(c.StorageShards) == 0 {
+		storageConfig := storage.DefaultCollectionConfig()
+		c.StorageCollection, err = storage.NewCollection(storageConfig)
+		if err != nil {
+			return maskAny(err)
+		}
 	}
 
-	return config
+	return nil
 }
 
 // NewService creates a new configured event service.
@@ -90,12 +308,26 @@ func NewService(config ServiceConfig) (Service, error) {
 	if config.BackoffService == nil {
 		return nil, maskAnyf(invalidConfigError, "backoff service must not be empty")
 	}
+	if config.Clock == nil {
+		return nil, maskAnyf(invalidConfigError, "clock must not be empty")
+	}
+	if config.IDGenerator == nil {
+		return nil, maskAnyf(invalidConfigError, "id generator must not be empty")
+	}
 	if config.InstrumentorCollection == nil {
 		return nil, maskAnyf(invalidConfigError, "instrumentor collection must not be empty")
 	}
-	if config.StorageCollection == nil {
+	if config.StorageCollection == nil && len(config.StorageShards) == 0 {
 		return nil, maskAnyf(invalidConfigError, "storage collection must not be empty")
 	}
+	for name, c := range config.StorageShards {
+		if c == nil {
+			return nil, maskAnyf(invalidConfigError, "storage shard %s must not be empty", name)
+		}
+	}
+	if config.SecondaryStorageCollection != nil && len(config.StorageShards) != 0 {
+		return nil, maskAnyf(invalidConfigError, "secondary storage collection must not be used with storage shards")
+	}
 
 	// Settings.
 	if config.Kind == "" {
@@ -104,20 +336,168 @@ func NewService(config ServiceConfig) (Service, error) {
 	if config.Kind != KindActivator && config.Kind != KindNetwork {
 		return nil, maskAnyf(invalidConfigError, "kind must be %s or %s", KindActivator, KindNetwork)
 	}
+	if config.KeyPrefix == "" {
+		return nil, maskAnyf(invalidConfigError, "key prefix must not be empty")
+	}
+	if config.BatchSize < 0 {
+		return nil, maskAnyf(invalidConfigError, "batch size must not be negative")
+	}
+	if config.BatchSize > 0 && config.BatchConcurrency < 1 {
+		return nil, maskAnyf(invalidConfigError, "batch concurrency must be 1 or greater")
+	}
+	if config.BatchSize > 0 && config.BatchInterval <= 0 {
+		return nil, maskAnyf(invalidConfigError, "batch interval must be greater than 0")
+	}
+	if config.BreakerFailureThreshold < 0 {
+		return nil, maskAnyf(invalidConfigError, "breaker failure threshold must not be negative")
+	}
+	if config.BreakerFailureThreshold > 0 && config.BreakerOpenDuration <= 0 {
+		return nil, maskAnyf(invalidConfigError, "breaker open duration must be greater than 0")
+	}
+	if config.BreakerFailureThreshold > 0 && config.BreakerProbes < 1 {
+		return nil, maskAnyf(invalidConfigError, "breaker probes must be 1 or greater")
+	}
+	if config.ChunkSize < 0 {
+		return nil, maskAnyf(invalidConfigError, "chunk size must not be negative")
+	}
+	if config.Codec == nil {
+		return nil, maskAnyf(invalidConfigError, "codec must not be empty")
+	}
+	for _, c := range append([]Codec{config.Codec}, config.Codecs...) {
+		if c == nil || c.Name() == "" || strings.Contains(c.Name(), ":") {
+			return nil, maskAnyf(invalidConfigError, "codec names must not be empty or contain ':'")
+		}
+		if isCompression(c.Name()) || c.Name() == chunkTag || c.Name() == encryptionTag {
+			return nil, maskAnyf(invalidConfigError, "codec name %s is reserved", c.Name())
+		}
+	}
+	if config.Compression != "" && !isCompression(config.Compression) {
+		return nil, maskAnyf(invalidConfigError, "compression must be %s, %s or %s", CompressionGzip, CompressionSnappy, CompressionZstd)
+	}
+	if config.CompressionThreshold < 0 {
+		return nil, maskAnyf(invalidConfigError, "compression threshold must not be negative")
+	}
+	if config.MaxDecompressedSize <= 0 {
+		return nil, maskAnyf(invalidConfigError, "max decompressed size must be greater than 0")
+	}
+	for keyID := range config.Keyring.Keys {
+		if keyID == "" || strings.Contains(keyID, ":") {
+			return nil, maskAnyf(invalidConfigError, "key IDs must not be empty or contain ':'")
+		}
+	}
+	if _, ok := config.Keyring.Keys[config.Keyring.Active]; config.Keyring.Active != "" && !ok {
+		return nil, maskAnyf(invalidConfigError, "active key %s must be in keyring", config.Keyring.Active)
+	}
+	ciphers, err := newCiphers(config.Keyring)
+	if err != nil {
+		return nil, maskAnyf(invalidConfigError, "keyring must hold AES keys: %s", err)
+	}
+	if config.CompactionMode != "" && config.CompactionMode != CompactionReplace && config.CompactionMode != CompactionDrop {
+		return nil, maskAnyf(invalidConfigError, "compaction mode must be %s or %s", CompactionReplace, CompactionDrop)
+	}
+	if config.SecondaryStorageCollection != nil && config.HealthCheckInterval <= 0 {
+		return nil, maskAnyf(invalidConfigError, "health check interval must be greater than 0")
+	}
+	if config.SecondaryStorageCollection != nil && config.HealthCheckFailures <= 0 {
+		return nil, maskAnyf(invalidConfigError, "health check failures must be greater than 0")
+	}
+	for _, p := range config.RetentionPolicies {
+		_, err := path.Match(p.Pattern, "")
+		if err != nil {
+			return nil, maskAnyf(invalidConfigError, "retention policy pattern %q must be valid", p.Pattern)
+		}
+		if p.MaxAge < 0 || p.MaxBytes < 0 || p.MaxCount < 0 {
+			return nil, maskAnyf(invalidConfigError, "retention policy limits must not be negative")
+		}
+	}
+	if strings.Contains(config.Tenant, ":") {
+		return nil, maskAnyf(invalidConfigError, "tenant must not contain ':'")
+	}
+	if len(config.RetentionPolicies) != 0 && config.RetentionInterval <= 0 {
+		return nil, maskAnyf(invalidConfigError, "retention interval must be greater than 0")
+	}
 
 	newService := &service{
 		// Dependencies.
 		backoff:      config.BackoffService,
+		clock:        config.Clock,
+		idGenerator:  config.IDGenerator,
 		instrumentor: config.InstrumentorCollection,
-		storage:      config.StorageCollection,
+		mirror:       nil,
+		shards:       nil,
 
 		// Internals.
-		bootOnce:     sync.Once{},
+		batch:        nil,
+		batchClosed:  false,
+		batchFull:    make(chan struct{}, 1),
+		batchMutex:   sync.Mutex{},
+		bootErr:      nil,
+		bootMutex:    sync.RWMutex{},
+		bootRunMutex: sync.Mutex{},
+		booted:       false,
+		ciphers:      ciphers,
 		closer:       make(chan struct{}, 1),
+		flushMutex:   sync.Mutex{},
+		flushOnce:    sync.Once{},
+		moveMutex:    sync.RWMutex{},
 		shutdownOnce: sync.Once{},
 
 		// Settings.
-		kind: config.Kind,
+		acceptUnencrypted:    config.AcceptUnencrypted,
+		archiver:             config.Archiver,
+		batchConcurrency:     config.BatchConcurrency,
+		batchInterval:        config.BatchInterval,
+		batchSize:            config.BatchSize,
+		chunkSize:            config.ChunkSize,
+		codec:                config.Codec,
+		codecs:               map[string]Codec{},
+		compactionMode:       config.CompactionMode,
+		compression:          config.Compression,
+		compressionThreshold: config.CompressionThreshold,
+		divergenceNotifier:   config.DivergenceNotifier,
+		failoverNotifier:     config.FailoverNotifier,
+		healthCheckInterval:  config.HealthCheckInterval,
+		keyPrefix:            config.KeyPrefix,
+		keyring:              config.Keyring,
+		maxDecompressedSize:  config.MaxDecompressedSize,
+		kind:                 config.Kind,
+		migrations:           config.Migrations,
+		quarantineTampered:   config.QuarantineTampered,
+		retentionInterval:    config.RetentionInterval,
+		retentionPolicies:    config.RetentionPolicies,
+		schemaRegistry:       config.SchemaRegistry,
+		signingSecret:        config.SigningSecret,
+		tenant:               config.Tenant,
+		tenantFunc:           config.TenantFunc,
+		tenantQuota:          config.TenantQuota,
+		tenantQuotas:         config.TenantQuotas,
+		upcasterRegistry:     config.UpcasterRegistry,
+	}
+
+	for _, c := range []Codec{NewGobCodec(), NewJSONCodec(), NewMessagePackCodec(), config.Codec} {
+		newService.codecs[c.Name()] = c
+	}
+	for _, c := range config.Codecs {
+		newService.codecs[c.Name()] = c
+	}
+
+	{
+		m := map[string]Storage{}
+		if config.SecondaryStorageCollection != nil {
+			newService.mirror = newMirror(config.StorageCollection.Event, config.SecondaryStorageCollection.Event, newService.diverged, newService.failedOver, config.HealthCheckFailures)
+			m[ShardDefault] = newService.mirror
+		} else if len(config.StorageShards) == 0 {
+			m[ShardDefault] = config.StorageCollection.Event
+		}
+		for name, c := range config.StorageShards {
+			m[name] = c.Event
+		}
+		if config.BreakerFailureThreshold > 0 {
+			for name, shard := range m {
+				m[name] = newBreaker(shard, config.Clock, config.BreakerFailureThreshold, config.BreakerOpenDuration, config.BreakerProbes, newService.breakerChanged)
+			}
+		}
+		newService.shards = newRing(m)
 	}
 
 	return newService, nil
@@ -126,45 +506,157 @@ func NewService(config ServiceConfig) (Service, error) {
 type service struct {
 	// Dependencies.
 	backoff      func() Backoff
+	clock        Clock
+	idGenerator  IDGenerator
 	instrumentor *instrumentor.Collection
-	storage      *storage.Collection
+	mirror       *mirror
+	shards       *ring
 
 	// Internals.
-	bootOnce     sync.Once
+	batch        []pendingCreate
+	batchClosed  bool
+	batchFull    chan struct{}
+	batchMutex   sync.Mutex
+	bootErr      error
+	bootMutex    sync.RWMutex
+	bootRunMutex sync.Mutex
+	booted       bool
+	ciphers      map[string]cipher.AEAD
 	closer       chan struct{}
+	flushMutex   sync.Mutex
+	flushOnce    sync.Once
+	moveMutex    sync.RWMutex
 	shutdownOnce sync.Once
 
 	// Settings.
-	kind string
+	acceptUnencrypted    bool
+	archiver             func(namespace string, event Event) error
+	batchConcurrency     int
+	batchInterval        time.Duration
+	batchSize            int
+	chunkSize            int
+	codec                Codec
+	codecs               map[string]Codec
+	compactionMode       string
+	compression          string
+	compressionThreshold int
+	divergenceNotifier   func(key string, err error)
+	failoverNotifier     func(err error)
+	healthCheckInterval  time.Duration
+	keyPrefix            string
+	keyring              Keyring
+	kind                 string
+	maxDecompressedSize  int
+	migrations           map[int]Migration
+	quarantineTampered   bool
+	retentionInterval    time.Duration
+	retentionPolicies    []RetentionPolicy
+	schemaRegistry       SchemaRegistry
+	signingSecret        []byte
+	tenant               string
+	tenantFunc           func(ctx context.Context) (string, error)
+	tenantQuota          Quota
+	tenantQuotas         map[string]Quota
+	upcasterRegistry     UpcasterRegistry
 }
 
 func (s *service) Boot() {
-	s.bootOnce.Do(func() {
-		// Service specific boot logic goes here.
-	})
+	s.bootRunMutex.Lock()
+	defer s.bootRunMutex.Unlock()
+
+	if s.booted {
+		return
+	}
+
+	// In case the stored schema cannot be brought to the schema version of the
+	// code, the service refuses to operate on the stored data. All upcoming
+	// calls to the service return the error until Boot is called again and
+	// succeeds. Storage failures are retried using the configured backoff, while
+	// invalid schemas are not going to change by retrying.
+	var abort error
+	retry := func() error {
+		err := s.migrate()
+		if IsInvalidSchema(err) {
+			abort = err
+			return nil
+		}
+		return err
+	}
+	err := backoff.RetryNotify(retry, s.backoff(), s.retryNotifier)
+	if err == nil {
+		err = abort
+	}
+
+	s.bootMutex.Lock()
+	s.bootErr = nil
+	if err != nil {
+		s.bootErr = maskAny(err)
+	}
+	s.bootMutex.Unlock()
+
+	if err != nil {
+		return
+	}
+	s.booted = true
+
+	if s.mirror != nil {
+		go s.checkHealth()
+	}
+	if len(s.retentionPolicies) != 0 {
+		go s.retain()
+	}
+	if s.batchSize > 0 {
+		s.startFlushing()
+	}
 }
 
 func (s *service) Create(ctx context.Context, event Event, labels ...string) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 	if namespace == LabelWildcard {
 		return maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
 	}
 
-	// Register the namespace in the lookup table. Duplicated elements will be
-	// ignored so we can simply fire and forget.
-	err := s.storage.Event.PushToSet(s.tableKey(), namespace)
+	event, err = s.stamp(event)
 	if err != nil {
 		return maskAny(err)
 	}
 
-	// Publish the event ID in its namespaced queue.
-	err = s.storage.Event.PushToList(s.namespaceKey(namespace), event.ID())
+	// In the buffered producer mode the event is only queued for the next flush.
+	// Errors are only returned in case the flush already happened. Callers
+	// interested in durability use Service.CreateAsync.
+	if s.batchSize > 0 {
+		f := s.CreateAsync(ctx, event, labels...)
+		select {
+		case <-f.Done():
+			return maskAny(f.Err())
+		default:
+			return nil
+		}
+	}
+
+	err = s.validate(event, labels...)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	sc, err := s.scope(ctx)
 	if err != nil {
 		return maskAny(err)
 	}
+	shard := s.shardFor(sc, namespace)
 
-	// Store the event payload.
-	err = s.storage.Event.Set(s.eventKey(event.ID()), event.Payload())
+	// Register the namespace in the lookup table.
+	err = s.register(shard, sc, namespace)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = s.publish(shard, sc, namespace, event)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -173,12 +665,22 @@ func (s *service) Create(ctx context.Context, event Event, labels ...string) err
 }
 
 func (s *service) Delete(ctx context.Context, event Event, labels ...string) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 	if namespace == LabelWildcard {
 		return maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
 	}
 
-	err := s.storage.Event.Remove(s.eventKey(event.ID()))
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = s.remove(s.shardFor(sc, namespace), sc, event.ID())
 	if err != nil {
 		return maskAny(err)
 	}
@@ -187,6 +689,11 @@ func (s *service) Delete(ctx context.Context, event Event, labels ...string) err
 }
 
 func (s *service) ExistsAny(ctx context.Context, labels ...string) (bool, error) {
+	err := s.bootError()
+	if err != nil {
+		return false, maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 	if namespace == LabelWildcard {
 		return false, maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
@@ -196,7 +703,12 @@ func (s *service) ExistsAny(ctx context.Context, labels ...string) (bool, error)
 	// labels exists. Therefore we only have to check if a list for our namespace
 	// exists at all, because the underlying list is automatically removed by the
 	// storage service in case there are no longer events queued within it.
-	ok, err := s.storage.Event.Exists(s.namespaceKey(namespace))
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return false, maskAny(err)
+	}
+
+	ok, err := s.shardFor(sc, namespace).Exists(sc.namespaceKey(namespace))
 	if err != nil {
 		return false, maskAny(err)
 	}
@@ -208,6 +720,11 @@ func (s *service) ExistsAny(ctx context.Context, labels ...string) (bool, error)
 }
 
 func (s *service) Limit(ctx context.Context, max int, labels ...string) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
 	if max < 1 {
 		return maskAnyf(invalidExecutionError, "max must be 1 or greater")
 	}
@@ -217,80 +734,197 @@ func (s *service) Limit(ctx context.Context, max int, labels ...string) error {
 		return maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
 	}
 
-	err := s.storage.Event.TrimEndOfList(s.namespaceKey(namespace), max)
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	shard := s.shardFor(sc, namespace)
+
+	// The payloads of trimmed events are left behind unless anything else is
+	// stored along with them, which is the case for chunks, compaction keys and
+	// tracked stored bytes. Removing them requires to know the trimmed events.
+	if s.chunkSize == 0 && s.compactionMode == "" && s.quota(sc).MaxStoredBytes == 0 {
+		err = shard.TrimEndOfList(sc.namespaceKey(namespace), max)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	n, err := listLength(shard, sc.namespaceKey(namespace))
 	if err != nil {
 		return maskAny(err)
 	}
+	if n <= max {
+		return nil
+	}
+
+	// The storage pushes new elements to the head of a list. The events trimmed
+	// off are thus the oldest ones, which are evicted atomically, so that events
+	// pushed in the meantime do not push out any other event.
+	evicted, err := evictFromList(shard, sc.namespaceKey(namespace), n-max)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, eventID := range evicted {
+		err := s.remove(shard, sc, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
 
 	return nil
 }
 
 func (s *service) Search(ctx context.Context, labels ...string) (Event, error) {
+	e, _, err := s.search(ctx, labels...)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return e, nil
+}
+
+// search implements Service.Search and additionally returns the namespace the
+// event was consumed from, which the wildcard label does not tell.
+func (s *service) search(ctx context.Context, labels ...string) (Event, string, error) {
+	err := s.bootError()
+	if err != nil {
+		return nil, "", maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return nil, "", maskAny(err)
+	}
+
+	var abort error
 	var event Event
-	var err error
+	var consumed string
 	action := func() error {
+		// fail quarantines the popped event that cannot be consumed because of
+		// the given error and stops retrying, because the next retry would pop
+		// and lose the next event.
+		fail := func(shard Storage, namespace, eventID string, err error) {
+			abort = maskAny(err)
+			qerr := s.quarantine(shard, sc, namespace, eventID, err)
+			if qerr != nil {
+				abort = maskAny(qerr)
+			}
+		}
 
-		// If the caller wants to consume any event using the wildcard label it
-		// might happen that there is no event at all. Then a not found error is
-		// received. This causes the retry action to fail. The failed action is
-		// retried based on the rules of the backoff service and its retry capacity.
-		if namespace == LabelWildcard {
-			namespace, err = s.storage.Event.GetRandomFromSet(s.tableKey())
+		for {
+			namespace := namespace
+			shardName := s.shards.Owner(sc.namespaceKey(namespace))
+
+			// If the caller wants to consume any event using the wildcard label it
+			// might happen that there is no event at all. Then a not found error is
+			// received. This causes the retry action to fail. The failed action is
+			// retried based on the rules of the backoff service and its retry
+			// capacity. A new namespace is chosen on every retry.
+			if namespace == LabelWildcard {
+				shardName, namespace, err = s.randomNamespace(sc)
+				if err != nil {
+					return maskAny(err)
+				}
+			}
+			shard := s.shards.Shard(shardName)
+
+			eventID, err := shard.PopFromList(sc.namespaceKey(namespace))
 			if err != nil {
 				return maskAny(err)
 			}
-		}
 
-		eventID, err := s.storage.Event.PopFromList(s.namespaceKey(namespace))
-		if err != nil {
-			return maskAny(err)
-		}
+			ok, err := shard.Exists(sc.namespaceKey(namespace))
+			if err != nil {
+				return maskAny(err)
+			}
+			if !ok {
+				err := shard.RemoveFromSet(sc.tableKey(), namespace)
+				if err != nil {
+					return maskAny(err)
+				}
+			}
 
-		ok, err := s.ExistsAny(ctx, labels...)
-		if err != nil {
-			return maskAny(err)
-		}
-		if !ok {
-			err := s.storage.Event.RemoveFromSet(s.tableKey(), namespace)
+			err = s.releaseCompacted(shard, sc, eventID)
 			if err != nil {
 				return maskAny(err)
 			}
-		}
 
-		// Fetching the actually queued event might fail if the caller already
-		// deleted the event. Then we receive a not found error and the failed retry
-		// action will be retried by the backoff service, depending of its
-		// configured rules and retry budged.
-		rawEvent, err := s.storage.Event.Get(s.eventKey(eventID))
-		if err != nil {
-			return maskAny(err)
-		}
+			// Fetching the actually queued event might fail if the caller already
+			// deleted the event. Then we receive a not found error and the failed
+			// retry action will be retried by the backoff service, depending of its
+			// configured rules and retry budged.
+			rawEvent, err := shard.Get(sc.eventKey(eventID))
+			if isNotFound(err) {
+				return maskAny(err)
+			} else if err != nil {
+				fail(shard, namespace, eventID, err)
+				return nil
+			}
 
-		newEvent, err := New(DefaultConfig())
-		if err != nil {
-			return maskAny(err)
-		}
-		err = json.Unmarshal([]byte(rawEvent), &newEvent)
-		if err != nil {
-			return maskAny(err)
+			// Events dropped by compaction are skipped. Their marker is removed
+			// here, because no consumer is going to delete it.
+			if rawEvent == compactedPayload {
+				err := s.remove(shard, sc, eventID)
+				if err != nil {
+					return maskAny(err)
+				}
+				continue
+			}
+
+			rawEvent, err = s.load(shard, sc, eventID, rawEvent)
+			if err != nil {
+				fail(shard, namespace, eventID, err)
+				return nil
+			}
+			newEvent, err := s.unmarshal(sc, namespace, eventID, rawEvent)
+			if err != nil {
+				fail(shard, namespace, eventID, err)
+				return nil
+			}
+			event = newEvent
+			consumed = namespace
+
+			return nil
 		}
-		event = newEvent
+	}
 
-		return nil
+	// Retrying does not help while the storage is unavailable. The error is
+	// then returned right away instead of burning the retry budget. The same
+	// applies to popped events that cannot be consumed, which callers have to be
+	// told about.
+	retry := func() error {
+		err := action()
+		if IsUnavailable(err) {
+			abort = err
+			return nil
+		}
+		return err
 	}
 
 	// TODO use the proper backoff service
-	err = backoff.RetryNotify(s.instrumentor.Publisher.WrapFunc("Search", action), s.backoff(), s.retryNotifier)
+	err = backoff.RetryNotify(s.instrumentor.Publisher.WrapFunc("Search", retry), s.backoff(), s.retryNotifier)
 	if err != nil {
-		return nil, maskAny(err)
+		return nil, "", maskAny(err)
+	}
+	if abort != nil {
+		return nil, "", maskAny(abort)
 	}
 
-	return event, nil
+	return event, consumed, nil
 }
 
 func (s *service) SearchAll(ctx context.Context, labels ...string) ([]Event, error) {
+	err := s.bootError()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 	if namespace == LabelWildcard {
 		return nil, maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
@@ -309,25 +943,46 @@ func (s *service) SearchAll(ctx context.Context, labels ...string) ([]Event, err
 		return nil, maskAny(notFoundError)
 	}
 
-	eventIDs, err := s.storage.Event.GetAllFromList(s.namespaceKey(namespace))
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	shard := s.shardFor(sc, namespace)
+
+	eventIDs, err := shard.GetAllFromList(sc.namespaceKey(namespace))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	eventIDs, rawEvents, err := s.storedEvents(shard, sc, eventIDs)
 	if err != nil {
 		return nil, maskAny(err)
 	}
 
 	var events []Event
 
-	for _, eventID := range eventIDs {
-		rawEvent, err := s.storage.Event.Get(s.eventKey(eventID))
-		if err != nil {
-			return nil, maskAny(err)
+	for i, rawEvent := range rawEvents {
+		if rawEvent == compactedPayload {
+			continue
 		}
 
-		newEvent, err := New(DefaultConfig())
+		rawEvent, err = s.load(shard, sc, eventIDs[i], rawEvent)
 		if err != nil {
+			// The chunks of an event removed while it is read are gone as well.
+			ok, eerr := shard.Exists(sc.eventKey(eventIDs[i]))
+			if eerr != nil {
+				return nil, maskAny(eerr)
+			}
+			if !ok {
+				continue
+			}
 			return nil, maskAny(err)
 		}
-		err = json.Unmarshal([]byte(rawEvent), &newEvent)
-		if err != nil {
+		newEvent, err := s.unmarshal(sc, namespace, eventIDs[i], rawEvent)
+		if IsTampered(err) {
+			s.count("Tampered")
+			return nil, maskAny(err)
+		} else if err != nil {
 			return nil, maskAny(err)
 		}
 
@@ -340,15 +995,33 @@ func (s *service) SearchAll(ctx context.Context, labels ...string) ([]Event, err
 func (s *service) Shutdown() {
 	s.shutdownOnce.Do(func() {
 		close(s.closer)
+
+		if s.batchSize > 0 {
+			s.shutdownBatch()
+		}
 	})
 }
 
 func (s *service) WriteAll(ctx context.Context, events []Event, labels ...string) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
 	namespace := s.namespaceFromLabels(labels...)
 	if namespace == LabelWildcard {
 		return maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
 	}
 
+	// All events are validated before the queued events are replaced, so that
+	// an invalid event does not leave the namespace empty.
+	for _, e := range events {
+		err := s.validate(e, labels...)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
 	for {
 		ok, err := s.ExistsAny(ctx, labels...)
 		if err != nil {
@@ -367,8 +1040,276 @@ func (s *service) WriteAll(ctx context.Context, events []Event, labels ...string
 		}
 	}
 
+	// The events are created asynchronously, so that they are flushed in batches
+	// in the buffered producer mode. WriteAll still only returns once all events
+	// are written.
+	var futures []Future
 	for _, e := range events {
-		err := s.Create(ctx, e, labels...)
+		futures = append(futures, s.CreateAsync(ctx, e, labels...))
+	}
+	for _, f := range futures {
+		err := f.Err()
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) bootError() error {
+	s.bootMutex.RLock()
+	defer s.bootMutex.RUnlock()
+
+	return s.bootErr
+}
+
+// checkHealth periodically checks the primary storage of the mirror until the
+// service is shut down.
+func (s *service) checkHealth() {
+	ticker := s.clock.NewTicker(s.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closer:
+			return
+		case <-ticker.C():
+			s.mirror.Check(s.versionKey())
+		}
+	}
+}
+
+// breakerChanged counts the state changes of circuit breakers per state
+// changed to.
+func (s *service) breakerChanged(from, to string) {
+	s.count("CircuitBreaker." + to)
+}
+
+// count increments the counter with the given key. Instrumentation failures do
+// not fail the counted operation.
+func (s *service) count(key string) {
+	c, err := s.instrumentor.Publisher.GetCounter(key)
+	if err != nil {
+		return
+	}
+	c.IncrBy(1)
+}
+
+// diverged reports keys holding different data within the primary and the
+// secondary storage.
+func (s *service) diverged(key string, err error) {
+	s.count("Mirror.Diverged")
+
+	if s.divergenceNotifier != nil {
+		s.divergenceNotifier(key, err)
+	}
+}
+
+// failedOver reports the failover to the secondary storage.
+func (s *service) failedOver(err error) {
+	s.count("Mirror.FailedOver")
+
+	if s.failoverNotifier != nil {
+		s.failoverNotifier(err)
+	}
+}
+
+// appendMany pushes the given elements to the end of the given list, keeping
+// their order. For storages not implementing ListAppender, the list is rebuilt
+// by pushing the given elements before the elements the list held before,
+// which is not atomic.
+func appendMany(shard Storage, key string, elements []string) error {
+	if a, ok := shard.(ListAppender); ok {
+		err := a.AppendManyToList(key, elements)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	existing, err := shard.GetAllFromList(key)
+	if err != nil {
+		return maskAny(err)
+	}
+	err = shard.Remove(key)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	// The storage pushes new elements to the head of a list, so the oldest
+	// element is pushed first.
+	all := append(existing, elements...)
+	var reversed []string
+	for i := len(all) - 1; i >= 0; i-- {
+		reversed = append(reversed, all[i])
+	}
+	err = pushMany(shard, key, reversed)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// evictFromList removes up to the given number of the oldest elements of the
+// given list and returns them. Storages implementing ListEvicter evict the
+// elements atomically.
+func evictFromList(shard Storage, key string, n int) ([]string, error) {
+	if e, ok := shard.(ListEvicter); ok {
+		elements, err := e.EvictFromList(key, n)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return elements, nil
+	}
+
+	elements, err := shard.GetAllFromList(key)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if n > len(elements) {
+		n = len(elements)
+	}
+
+	// Trimming a list to zero elements is not supported by the storage, so the
+	// list is removed instead.
+	keep := len(elements) - n
+	if keep == 0 {
+		err = shard.Remove(key)
+	} else {
+		err = shard.TrimEndOfList(key, keep)
+	}
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return elements[keep:], nil
+}
+
+// getMany fetches the values of the given keys from the given storage. Storages
+// implementing MultiGetter are asked for all values within a single round trip.
+// Other storages, like the event storage of storage.Collection, are asked for
+// the values of up to getManyConcurrency keys concurrently, so that their round
+// trips overlap.
+func getMany(shard Storage, keys []string) ([]string, error) {
+	if m, ok := shard.(MultiGetter); ok {
+		values, err := m.GetMany(keys)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return values, nil
+	}
+
+	values := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, getManyConcurrency)
+
+	for i, key := range keys {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+
+			values[i], errs[i] = shard.Get(key)
+
+			<-semaphore
+		}(i, key)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	return values, nil
+}
+
+// isNotFound returns whether the given error is a not found error of the event
+// service or of the storage.
+func isNotFound(err error) bool {
+	return IsNotFound(err) || storage.IsNotFound(err)
+}
+
+// incrementBy adds the given delta to the integer stored under the given key
+// and returns the result. Storages implementing Counter increment atomically.
+func incrementBy(shard Storage, key string, delta int) (int, error) {
+	if c, ok := shard.(Counter); ok {
+		n, err := c.IncrementBy(key, delta)
+		if err != nil {
+			return 0, maskAny(err)
+		}
+
+		return n, nil
+	}
+
+	var n int
+	ok, err := shard.Exists(key)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if ok {
+		raw, err := shard.Get(key)
+		if err != nil {
+			return 0, maskAny(err)
+		}
+		n, err = strconv.Atoi(raw)
+		if err != nil {
+			return 0, maskAnyf(invalidExecutionError, "counter %s must be a number, got %q", key, raw)
+		}
+	}
+	n += delta
+	err = shard.Set(key, strconv.Itoa(n))
+	if err != nil {
+		return 0, maskAny(err)
+	}
+
+	return n, nil
+}
+
+// listLength returns the number of elements of the given list. Storages
+// implementing ListLengther are asked for the length without fetching the
+// elements.
+func listLength(shard Storage, key string) (int, error) {
+	if l, ok := shard.(ListLengther); ok {
+		n, err := l.LengthOfList(key)
+		if err != nil {
+			return 0, maskAny(err)
+		}
+
+		return n, nil
+	}
+
+	elements, err := shard.GetAllFromList(key)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+
+	return len(elements), nil
+}
+
+// pushMany pushes the given elements to the given list in order. Storages
+// implementing MultiPusher are asked to push all elements within a single round
+// trip.
+func pushMany(shard Storage, key string, elements []string) error {
+	if m, ok := shard.(MultiPusher); ok {
+		err := m.PushManyToList(key, elements)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	for _, element := range elements {
+		err := shard.PushToList(key, element)
 		if err != nil {
 			return maskAny(err)
 		}
@@ -377,14 +1318,133 @@ func (s *service) WriteAll(ctx context.Context, events []Event, labels ...string
 	return nil
 }
 
-func (s *service) eventKey(eventID string) string {
-	return fmt.Sprintf("service:event:kind:%s:event:%s", s.kind, eventID)
+// marshal returns the stored representation of the given event within the
+// given namespace of the given scope.
+func (s *service) marshal(sc scope, namespace string, event Event) ([]byte, error) {
+	var signature []byte
+	if len(s.signingSecret) != 0 {
+		signature = sign(s.signingSecret, placementOf(sc, namespace, event.ID()), event)
+	}
+
+	b, err := encode(event, s.codec, signature)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	b, err = s.compress(b)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	b, err = s.encrypt(sc.eventKey(event.ID()), b)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
+
+// unmarshal reverts marshal for the event stored under the given event ID
+// within the given namespace of the given scope. The event is upcast to the
+// latest version of its type in case an upcaster registry is configured.
+func (s *service) unmarshal(sc scope, namespace, eventID string, rawEvent string) (Event, error) {
+	newEvent, err := s.decodeStored(sc, namespace, eventID, []byte(rawEvent))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if s.upcasterRegistry != nil {
+		upcasted, err := s.upcasterRegistry.Upcast(newEvent)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+
+		return upcasted, nil
+	}
+
+	return newEvent, nil
 }
 
-// redis list
-// holding events
-func (s *service) namespaceKey(namespace string) string {
-	return fmt.Sprintf("service:event:kind:%s:namespace:%s", s.kind, namespace)
+// decodeStored decrypts, decompresses, decodes and verifies the event stored
+// under the given event ID within the given namespace of the given scope.
+func (s *service) decodeStored(sc scope, namespace, eventID string, rawEvent []byte) (*event, error) {
+	b, err := s.decrypt(sc.eventKey(eventID), rawEvent)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	b, err = s.decompress(b)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	newEvent, err := decode(eventID, b, s.codecs)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(s.signingSecret) != 0 {
+		err := verify(s.signingSecret, placementOf(sc, namespace, eventID), newEvent)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	return newEvent, nil
+}
+
+// publish queues the ID of the given event in its namespaced queue and stores
+// its payload.
+func (s *service) publish(shard Storage, sc scope, namespace string, event Event) error {
+	s.moveMutex.RLock()
+	defer s.moveMutex.RUnlock()
+
+	replaced, err := s.compact(shard, sc, namespace, event)
+	if err != nil {
+		return maskAny(err)
+	}
+	if replaced {
+		return nil
+	}
+
+	b, err := s.marshal(sc, namespace, event)
+	if err != nil {
+		return maskAny(s.uncompact(shard, sc, event.ID(), err))
+	}
+
+	err = s.checkQuota(shard, sc, namespace, b)
+	if err != nil {
+		return maskAny(s.uncompact(shard, sc, event.ID(), err))
+	}
+
+	// Store the event payload. The payload is written before the event ID is
+	// published, so consumers never pop an event without payload.
+	err = s.store(shard, sc, event.ID(), b)
+	if err != nil {
+		return maskAny(s.uncompact(shard, sc, event.ID(), s.release(sc, len(b), err)))
+	}
+
+	// Publish the event ID in its namespaced queue.
+	err = shard.PushToList(sc.namespaceKey(namespace), event.ID())
+	if err != nil {
+		return maskAny(s.unstore(shard, sc, event.ID(), err))
+	}
+
+	return nil
+}
+
+// randomNamespace returns a random namespace of a random shard that has any
+// namespace registered. Shards are tried in random order so that wildcard
+// searches consume events from all shards.
+func (s *service) randomNamespace(sc scope) (string, string, error) {
+	var err error
+
+	names := s.shards.All()
+	for _, i := range rand.Perm(len(names)) {
+		var namespace string
+		namespace, err = s.shards.Shard(names[i]).GetRandomFromSet(sc.tableKey())
+		if err != nil {
+			continue
+		}
+
+		return names[i], namespace, nil
+	}
+
+	return "", "", maskAny(err)
 }
 
 func (s *service) namespaceFromLabels(labels ...string) string {
@@ -395,15 +1455,65 @@ func (s *service) namespaceFromLabels(labels ...string) string {
 	return namespace
 }
 
+// shardFor returns the storage of the shard owning the given namespace.
+func (s *service) shardFor(sc scope, namespace string) Storage {
+	return s.shards.Shard(s.shards.Owner(sc.namespaceKey(namespace)))
+}
+
+// stamp returns the given event with an ID and creation time provided by the ID
+// generator and clock of the service, in case the given event lacks them.
+func (s *service) stamp(e Event) (Event, error) {
+	if e.ID() != "" && !e.Created().IsZero() {
+		return e, nil
+	}
+
+	newEvent := &event{
+		// Settings.
+		created: e.Created(),
+		headers: copyHeaders(e.Headers()),
+		id:      e.ID(),
+		payload: e.PayloadBytes(),
+	}
+	if ev, ok := e.(*event); ok {
+		newEvent.codec = ev.codec
+	}
+
+	if newEvent.created.IsZero() {
+		newEvent.created = s.clock.Now()
+	}
+	if newEvent.id == "" {
+		newID, err := s.idGenerator.New()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		newEvent.id = newID
+	}
+
+	return newEvent, nil
+}
+
+// validate validates the payload of the given event in case a schema registry
+// is configured.
+func (s *service) validate(event Event, labels ...string) error {
+	if s.schemaRegistry == nil {
+		return nil
+	}
+
+	err := s.schemaRegistry.Validate(event, labels...)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
 // TODO emit metrics in proper backoff service
 func (s *service) retryNotifier(err error, d time.Duration) {
 	//s.logger.Log("error", fmt.Sprintf("%#v", maskAny(err)))
 }
 
-// redis set
-// holding all namespaces
-// random member
-// check existence
-func (s *service) tableKey() string {
-	return fmt.Sprintf("service:event:kind:%s:table", s.kind)
+// redis string
+// holding the schema version
+func (s *service) versionKey() string {
+	return fmt.Sprintf("%s:kind:%s:version", s.keyPrefix, s.kind)
 }
@@ -0,0 +1,89 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const (
+	benchmarkEvents = 10000
+	// benchmarkLatency simulates the round trip to a storage in the same data
+	// center.
+	benchmarkLatency = 10 * time.Microsecond
+)
+
+// fillNamespace creates the given number of events in the namespace of the
+// given labels.
+func fillNamespace(b *testing.B, s *service, n int, labels ...string) {
+	for i := 0; i < n; i++ {
+		e, err := New(Config{Created: time.Unix(int64(i), 0), ID: fmt.Sprintf("e%d", i), Payload: "payload"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = s.Create(nil, e, labels...)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkSearchAll reads a namespace of 10k events and reports the storage
+// round trips it takes.
+func benchmarkSearchAll(b *testing.B, m *memoryStorage, s *service) {
+	fillNamespace(b, s, benchmarkEvents, "bench")
+
+	m.latency = benchmarkLatency
+	start := m.roundTrips()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		events, err := s.SearchAll(nil, "bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(events) != benchmarkEvents {
+			b.Fatalf("expected %d events, got %d", benchmarkEvents, len(events))
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(m.roundTrips()-start)/float64(b.N), "roundtrips/op")
+}
+
+// BenchmarkSearchAll10kPerKey measures storages that do not implement
+// MultiGetter, which includes the event storage of storage.Collection. Every
+// payload costs a round trip, but the round trips of concurrently fetched
+// payloads overlap.
+func BenchmarkSearchAll10kPerKey(b *testing.B) {
+	m := newMemoryStorage()
+	benchmarkSearchAll(b, m, newTestService(b, m, nil))
+}
+
+// BenchmarkSearchAll10kMultiGet measures storages implementing MultiGetter.
+// All payloads are fetched within a single round trip.
+func BenchmarkSearchAll10kMultiGet(b *testing.B) {
+	m := newMemoryStorage()
+	benchmarkSearchAll(b, m, newTestService(b, bulkStorage{m}, nil))
+}
+
+// BenchmarkDecode10k measures decoding 10k stored events, which does not
+// generate any IDs.
+func BenchmarkDecode10k(b *testing.B) {
+	e, err := New(Config{Created: time.Unix(1, 0), ID: "e", Payload: "payload"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	raw, err := encode(e, NewJSONCodec(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkEvents; j++ {
+			_, err := decode("e", raw, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
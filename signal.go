@@ -7,39 +7,71 @@ import (
 
 	"github.com/the-anna-project/context"
 	"github.com/the-anna-project/context/merge"
-	"github.com/the-anna-project/id"
 )
 
+// newContext creates the empty context signals are decoded into.
+var newContext = func() (context.Context, error) {
+	return context.New(context.DefaultConfig())
+}
+
+// signalEnvelope represents the payload of a signal event. Arguments are
+// encoded as JSON and are decoded into the types encoding/json decodes into an
+// empty interface. The context is encoded using its json.Marshaler
+// implementation and is decoded in case the context created by newContext
+// implements json.Unmarshaler.
+type signalEnvelope struct {
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+	Context   json.RawMessage   `json:"context,omitempty"`
+	Created   time.Time         `json:"created"`
+	ID        string            `json:"id"`
+}
+
 // SignalConfig represents the configuration used to create a new signal event.
 type SignalConfig struct {
+	// Dependencies.
+	// Clock provides the creation time of the signal in case Created is empty.
+	Clock Clock
+	// IDGenerator provides the ID of the signal in case ID is empty.
+	IDGenerator IDGenerator
+
 	// Settings.
 	Arguments []reflect.Value
-	Context   context.Context
-	Created   time.Time
-	ID        string
+	// Context is carried by the signal. It has to implement json.Marshaler, so
+	// that its values travel with the signal. Contexts not implementing it would
+	// lose their values, which is why NewSignal refuses them.
+	Context context.Context
+	Created time.Time
+	Headers map[string]string
+	ID      string
 }
 
 // DefaultSignalConfig provides a default configuration to create a new signal
-// event by best effort.
+// event by best effort. The configuration carries a new ID and the current
+// time, just like it did before the clock and the ID generator were
+// configurable. They are only used in case Created or ID are cleared. See
+// NewSignalWithOptions for creating signals using an injected clock or ID
+// generator.
 func DefaultSignalConfig() SignalConfig {
-	var newID string
-	{
-		idConfig := id.DefaultServiceConfig()
-		idService, err := id.NewService(idConfig)
-		if err != nil {
-			panic(err)
-		}
-		newID, err = idService.New()
-		if err != nil {
-			panic(err)
-		}
+	clock := NewClock()
+	idGenerator, err := defaultIDGenerator()
+	if err != nil {
+		panic(err)
+	}
+	newID, err := idGenerator.New()
+	if err != nil {
+		panic(err)
 	}
 
 	config := SignalConfig{
+		// Dependencies.
+		Clock:       clock,
+		IDGenerator: idGenerator,
+
 		// Settings.
 		Arguments: nil,
 		Context:   nil,
-		Created:   time.Now(),
+		Created:   clock.Now(),
+		Headers:   nil,
 		ID:        newID,
 	}
 
@@ -48,10 +80,25 @@ func DefaultSignalConfig() SignalConfig {
 
 // NewSignal creates a new configured signal event.
 func NewSignal(config SignalConfig) (Signal, error) {
+	// Dependencies.
+	if config.Created.IsZero() && config.Clock != nil {
+		config.Created = config.Clock.Now()
+	}
+	if config.ID == "" && config.IDGenerator != nil {
+		newID, err := config.IDGenerator.New()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		config.ID = newID
+	}
+
 	// Settings.
 	if config.Context == nil {
 		return nil, maskAnyf(invalidConfigError, "context must not be empty")
 	}
+	if _, ok := config.Context.(json.Marshaler); !ok {
+		return nil, maskAnyf(invalidConfigError, "context must implement json.Marshaler")
+	}
 	if config.Created.IsZero() {
 		return nil, maskAnyf(invalidConfigError, "created must not be empty")
 	}
@@ -61,27 +108,30 @@ func NewSignal(config SignalConfig) (Signal, error) {
 
 	newSignal := &signal{
 		// Internals.
-		payload: "",
+		payload: nil,
 
 		// Settings.
 		arguments: config.Arguments,
 		context:   config.Context,
 		created:   config.Created,
+		headers:   copyHeaders(config.Headers),
 		id:        config.ID,
 	}
 
-	b, err := json.Marshal(newSignal)
+	b, err := newSignal.envelope()
 	if err != nil {
 		return nil, maskAny(err)
 	}
-	newSignal.payload = string(b)
+	newSignal.payload = b
 
 	return newSignal, nil
 }
 
+// NewSignalFromEvent creates the signal carried by the given event, which has
+// to be a signal created by NewSignal or an event carrying its payload.
 func NewSignalFromEvent(event Event) (Signal, error) {
-	var newSignal *signal
-	err := json.Unmarshal([]byte(event.Payload()), newSignal)
+	newSignal := &signal{}
+	err := newSignal.setEnvelope(event.PayloadBytes())
 	if err != nil {
 		return nil, maskAny(err)
 	}
@@ -96,11 +146,15 @@ func NewSignalFromEvent(event Event) (Signal, error) {
 		return nil, maskAnyf(invalidConfigError, "id must not be empty")
 	}
 
-	newSignal.payload = event.Payload()
+	newSignal.headers = copyHeaders(event.Headers())
+	newSignal.payload = event.PayloadBytes()
 
 	return newSignal, nil
 }
 
+// NewSignalFromSignals creates a signal carrying the arguments of all given
+// signals and their contexts merged into the context created by newContext,
+// which has to implement json.Marshaler like any context of a signal.
 func NewSignalFromSignals(signals []Signal) (Signal, error) {
 	if len(signals) == 0 {
 		return nil, maskAnyf(invalidConfigError, "signals must not be empty")
@@ -117,7 +171,7 @@ func NewSignalFromSignals(signals []Signal) (Signal, error) {
 
 	var ctx context.Context
 	{
-		newCtx, err := context.New(context.DefaultConfig())
+		newCtx, err := newContext()
 		if err != nil {
 			return nil, maskAny(err)
 		}
@@ -133,10 +187,7 @@ func NewSignalFromSignals(signals []Signal) (Signal, error) {
 		}
 	}
 
-	config := DefaultSignalConfig()
-	config.Arguments = args
-	config.Context = ctx
-	newSignal, err := NewSignal(config)
+	newSignal, err := NewSignalWithOptions(WithSignalArguments(args...), WithSignalContext(ctx))
 	if err != nil {
 		return nil, maskAny(err)
 	}
@@ -146,13 +197,14 @@ func NewSignalFromSignals(signals []Signal) (Signal, error) {
 
 type signal struct {
 	// Internals.
-	payload string `json:"-"`
+	payload []byte
 
 	// Settings.
-	arguments []reflect.Value `json:"arguments"`
-	context   context.Context `json:"context"`
-	created   time.Time       `json:"created"`
-	id        string          `json:"id"`
+	arguments []reflect.Value
+	context   context.Context
+	created   time.Time
+	headers   map[string]string
+	id        string
 }
 
 func (s *signal) Arguments() []reflect.Value {
@@ -167,18 +219,16 @@ func (s *signal) Created() time.Time {
 	return s.created
 }
 
+func (s *signal) Headers() map[string]string {
+	return s.headers
+}
+
 func (s *signal) ID() string {
 	return s.id
 }
 
 func (s *signal) MarshalJSON() ([]byte, error) {
-	type Clone signal
-
-	b, err := json.Marshal(&struct {
-		*Clone
-	}{
-		Clone: (*Clone)(s),
-	})
+	b, err := s.envelope()
 	if err != nil {
 		return nil, maskAny(err)
 	}
@@ -187,23 +237,101 @@ func (s *signal) MarshalJSON() ([]byte, error) {
 }
 
 func (s *signal) UnmarshalJSON(b []byte) error {
-	type Clone signal
+	// The given bytes must not be retained after returning.
+	err := s.setEnvelope(append([]byte(nil), b...))
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
 
-	aux := &struct {
-		*Clone
-	}{
-		Clone: (*Clone)(s),
+// envelope returns the payload of the signal.
+func (s *signal) envelope() ([]byte, error) {
+	e := signalEnvelope{
+		Arguments: nil,
+		Context:   nil,
+		Created:   s.created,
+		ID:        s.id,
 	}
-	err := json.Unmarshal(b, &aux)
+	for _, a := range s.arguments {
+		var v interface{}
+		if a.IsValid() {
+			v = a.Interface()
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		e.Arguments = append(e.Arguments, b)
+	}
+	m, ok := s.context.(json.Marshaler)
+	if !ok {
+		return nil, maskAnyf(invalidExecutionError, "context must implement json.Marshaler")
+	}
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	e.Context = b
+
+	b, err = json.Marshal(e)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
+
+// setEnvelope sets the fields of the signal from the given payload, which is
+// retained as the payload of the signal.
+func (s *signal) setEnvelope(b []byte) error {
+	var e signalEnvelope
+	err := json.Unmarshal(b, &e)
 	if err != nil {
 		return maskAny(err)
 	}
 
-	s.payload = string(b)
+	var arguments []reflect.Value
+	for _, raw := range e.Arguments {
+		var a interface{}
+		err := json.Unmarshal(raw, &a)
+		if err != nil {
+			return maskAny(err)
+		}
+		arguments = append(arguments, reflect.ValueOf(a))
+	}
+
+	var ctx context.Context
+	if len(e.Context) != 0 {
+		newCtx, err := newContext()
+		if err != nil {
+			return maskAny(err)
+		}
+		u, ok := newCtx.(json.Unmarshaler)
+		if !ok {
+			return maskAnyf(invalidExecutionError, "context must be decodable")
+		}
+		err = u.UnmarshalJSON(e.Context)
+		if err != nil {
+			return maskAny(err)
+		}
+		ctx = newCtx
+	}
+
+	s.arguments = arguments
+	s.context = ctx
+	s.created = e.Created
+	s.id = e.ID
+	s.payload = b
 
 	return nil
 }
 
 func (s *signal) Payload() string {
+	return string(s.payload)
+}
+
+func (s *signal) PayloadBytes() []byte {
 	return s.payload
 }
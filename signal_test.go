@@ -0,0 +1,112 @@
+package event
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/the-anna-project/context"
+)
+
+// testContext is a context encoding its values as JSON.
+type testContext struct {
+	context.Context
+
+	values map[string]string
+}
+
+func (c *testContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.values)
+}
+
+func (c *testContext) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &c.values)
+}
+
+func Test_Signal_FromEvent(t *testing.T) {
+	defer func(f func() (context.Context, error)) { newContext = f }(newContext)
+	newContext = func() (context.Context, error) { return &testContext{}, nil }
+
+	config := DefaultSignalConfig()
+	config.Arguments = []reflect.Value{reflect.ValueOf("a"), reflect.ValueOf(1)}
+	config.Context = &testContext{values: map[string]string{"key": "value"}}
+	config.Created = time.Unix(1, 0).UTC()
+	config.Headers = map[string]string{HeaderType: "signal"}
+	config.ID = "1"
+	s, err := NewSignal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signal travels as an event.
+	e, err := New(Config{
+		Created:      s.Created(),
+		Headers:      s.Headers(),
+		ID:           s.ID(),
+		PayloadBytes: s.PayloadBytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := NewSignalFromEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID() != "1" || !decoded.Created().Equal(config.Created) {
+		t.Fatalf("expected signal 1 created at %s, got %s created at %s", config.Created, decoded.ID(), decoded.Created())
+	}
+	if !reflect.DeepEqual(decoded.Headers(), config.Headers) {
+		t.Fatalf("expected headers %#v, got %#v", config.Headers, decoded.Headers())
+	}
+	var arguments []interface{}
+	for _, a := range decoded.Arguments() {
+		arguments = append(arguments, a.Interface())
+	}
+	if !reflect.DeepEqual(arguments, []interface{}{"a", float64(1)}) {
+		t.Fatalf("expected arguments a and 1, got %#v", arguments)
+	}
+	ctx, ok := decoded.Context().(*testContext)
+	if !ok || ctx.values["key"] != "value" {
+		t.Fatalf("expected context holding key, got %#v", decoded.Context())
+	}
+}
+
+func Test_Signal_ProductionContext(t *testing.T) {
+	ctx, err := context.New(context.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultSignalConfig()
+	config.Context = ctx
+	s, err := NewSignal(config)
+
+	// Contexts that cannot be encoded are refused instead of losing their values
+	// on the way.
+	if _, ok := ctx.(json.Marshaler); !ok {
+		if !IsInvalidConfig(err) {
+			t.Fatalf("expected invalid config error, got %#v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(Config{
+		Created:      s.Created(),
+		ID:           s.ID(),
+		PayloadBytes: s.PayloadBytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewSignalFromEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload(), s.Payload()) {
+		t.Fatalf("expected payload %s, got %s", s.Payload(), decoded.Payload())
+	}
+}
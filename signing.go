@@ -0,0 +1,214 @@
+package event
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/the-anna-project/context"
+)
+
+// placement represents the location an event is stored at. Signatures cover
+// the placement of an event, so that a signed event cannot be moved to another
+// key, namespace or tenant.
+type placement struct {
+	key       string
+	namespace string
+	tenant    string
+}
+
+// placementOf returns the placement of the event with the given ID stored
+// within the given namespace of the given scope.
+func placementOf(sc scope, namespace, eventID string) placement {
+	return placement{key: sc.eventKey(eventID), namespace: namespace, tenant: sc.tenant}
+}
+
+// sign returns the HMAC-SHA256 signature of the given event stored at the given
+// placement using the given secret. The signature covers the placement and the
+// event's ID, creation time, headers and payload. These are written in a
+// canonical form, so that the signature does not depend on the codec an event
+// is stored with.
+func sign(secret []byte, p placement, e Event) []byte {
+	return digest(secret, e, p.key, p.namespace, p.tenant)
+}
+
+// digest returns the HMAC-SHA256 of the given fields followed by the fields of
+// the given event using the given secret.
+func digest(secret []byte, e Event, fields ...string) []byte {
+	mac := hmac.New(sha256.New, secret)
+
+	for _, f := range fields {
+		writeField(mac, []byte(f))
+	}
+
+	writeField(mac, []byte(e.ID()))
+
+	var created [8]byte
+	binary.BigEndian.PutUint64(created[:], uint64(e.Created().UnixNano()))
+	mac.Write(created[:])
+
+	headers := e.Headers()
+	var keys []string
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(keys)))
+	mac.Write(n[:])
+	for _, k := range keys {
+		writeField(mac, []byte(k))
+		writeField(mac, []byte(headers[k]))
+	}
+
+	writeField(mac, e.PayloadBytes())
+
+	return mac.Sum(nil)
+}
+
+// writeField writes the given field prefixed by its length, so that no two
+// distinct sequences of fields produce the same input to the signature.
+func writeField(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// verify checks the signature the given event stored at the given placement was
+// stored with. Events stored without signature, e.g. before signing was
+// enabled, do not verify either.
+func verify(secret []byte, p placement, e *event) error {
+	if !hmac.Equal(e.signature, sign(secret, p, e)) {
+		return maskAnyf(tamperedError, "signature of event %s does not verify", e.id)
+	}
+
+	return nil
+}
+
+// tampered handles the tampered event popped from the given namespace. The
+// event is either dropped or, in case quarantining is configured, its ID is
+// pushed to the quarantine of the namespace, keeping its stored representation
+// for inspection. Verification failures are counted in any case.
+func (s *service) tampered(shard Storage, sc scope, namespace, eventID string) error {
+	s.count("Tampered")
+
+	if s.quarantineTampered {
+		err := shard.PushToList(sc.quarantineKey(namespace), eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	err := s.remove(shard, sc, eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// quarantine handles the event of the given namespace, which cannot be consumed
+// because of the given error. Tampered events are handled by tampered. Other
+// events, e.g. ones failing to be decoded or upcast, are quarantined, so that
+// they are neither lost nor block the queue.
+func (s *service) quarantine(shard Storage, sc scope, namespace, eventID string, err error) error {
+	if IsTampered(err) {
+		err := s.tampered(shard, sc, namespace, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		return nil
+	}
+
+	s.count("Quarantined")
+
+	err = shard.PushToList(sc.quarantineKey(namespace), eventID)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+func (s *service) ClearQuarantine(ctx context.Context, labels ...string) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	namespace := s.namespaceFromLabels(labels...)
+	if namespace == LabelWildcard {
+		return maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
+	}
+
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+	shard := s.shardFor(sc, namespace)
+
+	// Only the events listed here are evicted, so that events quarantined in the
+	// meantime are neither lost nor left without their stored representation.
+	n, err := listLength(shard, sc.quarantineKey(namespace))
+	if err != nil {
+		return maskAny(err)
+	}
+	if n == 0 {
+		return nil
+	}
+	eventIDs, err := evictFromList(shard, sc.quarantineKey(namespace), n)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, eventID := range eventIDs {
+		err := s.remove(shard, sc, eventID)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) Quarantined(ctx context.Context, labels ...string) ([]string, error) {
+	err := s.bootError()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	namespace := s.namespaceFromLabels(labels...)
+	if namespace == LabelWildcard {
+		return nil, maskAnyf(invalidExecutionError, "wildcard namespace must only be used for Service.Search")
+	}
+
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	eventIDs, err := s.shardFor(sc, namespace).GetAllFromList(sc.quarantineKey(namespace))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	// The storage pushes new elements to the head of a list. The list is thus
+	// reversed to return the event IDs in the order they were quarantined.
+	for i, j := 0, len(eventIDs)-1; i < j; i, j = i+1, j-1 {
+		eventIDs[i], eventIDs[j] = eventIDs[j], eventIDs[i]
+	}
+
+	return eventIDs, nil
+}
+
+// redis list
+// holding the IDs of quarantined events
+func (c scope) quarantineKey(namespace string) string {
+	return fmt.Sprintf("%s:quarantine", c.namespaceKey(namespace))
+}
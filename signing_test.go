@@ -0,0 +1,142 @@
+package event
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// withTestSigningSecret configures the given configuration to sign stored
+// events.
+func withTestSigningSecret(config *ServiceConfig) {
+	config.SigningSecret = []byte("secret")
+}
+
+// queue queues the event with the given ID within the namespace of the given
+// labels, without storing the event.
+func queue(t *testing.T, s *service, m *memoryStorage, tenant, eventID string, labels ...string) {
+	err := m.PushToList(s.scopeOf(tenant).namespaceKey(s.namespaceFromLabels(labels...)), eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Service_Signing_MovedNamespace(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, withTestSigningSecret)
+
+	err := s.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queueing the event within another namespace must not pass verification.
+	queue(t, s, m, "", "1", "b")
+	_, err = s.Search(nil, "b")
+	if !IsTampered(err) {
+		t.Fatalf("expected tampered error, got %#v", err)
+	}
+}
+
+func Test_Service_Signing_MovedTenant(t *testing.T) {
+	m := newMemoryStorage()
+	a := newTestService(t, m, func(config *ServiceConfig) {
+		withTestSigningSecret(config)
+		config.Tenant = "a"
+	})
+	b := newTestService(t, m, func(config *ServiceConfig) {
+		withTestSigningSecret(config)
+		config.Tenant = "b"
+	})
+
+	err := a.Create(nil, newTestEvent(t, "1", "payload"), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copying the event to another tenant must not pass verification.
+	rawEvent, err := m.Get(a.scopeOf("a").eventKey("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Set(b.scopeOf("b").eventKey("1"), rawEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue(t, b, m, "b", "1", "a")
+	_, err = b.Search(nil, "a")
+	if !IsTampered(err) {
+		t.Fatalf("expected tampered error, got %#v", err)
+	}
+
+	// Importing the event into another tenant signs it for that tenant.
+	c := newTestService(t, m, func(config *ServiceConfig) {
+		withTestSigningSecret(config)
+		config.Tenant = "c"
+	})
+	var buf bytes.Buffer
+	err = a.Export(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Import(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := c.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "payload" {
+		t.Fatalf("expected payload, got %s", e.Payload())
+	}
+}
+
+func Test_Service_Signing_Quarantine(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, func(config *ServiceConfig) {
+		withTestSigningSecret(config)
+		config.QuarantineTampered = true
+	})
+
+	for _, eventID := range []string{"1", "2"} {
+		err := s.Create(nil, newTestEvent(t, eventID, "payload"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue(t, s, m, "", eventID, "b")
+		_, err = s.Search(nil, "b")
+		if !IsTampered(err) {
+			t.Fatalf("expected tampered error, got %#v", err)
+		}
+	}
+
+	eventIDs, err := s.Quarantined(nil, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(eventIDs, []string{"1", "2"}) {
+		t.Fatalf("expected quarantined events 1 and 2, got %#v", eventIDs)
+	}
+
+	err = s.ClearQuarantine(nil, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventIDs, err = s.Quarantined(nil, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eventIDs) != 0 {
+		t.Fatalf("expected empty quarantine, got %#v", eventIDs)
+	}
+	for _, eventID := range []string{"1", "2"} {
+		ok, err := m.Exists(s.scopeOf("").eventKey(eventID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("expected event %s to be removed", eventID)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/the-anna-project/context"
+)
+
+const (
+	// snapshotFormat identifies streams written by Service.Export.
+	snapshotFormat = "the-anna-project/event/snapshot"
+	// snapshotVersion represents the version of the snapshot format written by
+	// Service.Export. Version 2 added quarantined events and compaction keys.
+	// Snapshots of version 1 lack them and can still be imported.
+	snapshotVersion = 2
+)
+
+// snapshotHeader is the first line of every snapshot stream.
+type snapshotHeader struct {
+	Format string `json:"format"`
+	Kind   string `json:"kind"`
+	Schema int    `json:"schema"`
+	// Tenant is the tenant the snapshot was exported from. Encrypted and signed
+	// payloads are bound to the storage keys of that tenant and are rebound to
+	// the importing tenant.
+	Tenant  string `json:"tenant,omitempty"`
+	Version int    `json:"version"`
+}
+
+// snapshotEvent is a single queued or quarantined event of a snapshot stream.
+// Events are written in queue order, followed by the quarantined events of
+// their namespace in quarantine order, so importing them in stream order
+// restores the queues and quarantine lists. Payloads are encoded as base64,
+// because they are not guaranteed to be valid UTF-8.
+type snapshotEvent struct {
+	// CompactionKey is the compaction key the queued event is registered for.
+	CompactionKey string `json:"compactionkey,omitempty"`
+	ID            string `json:"id"`
+	Namespace     string `json:"namespace"`
+	Payload       []byte `json:"payload"`
+	Quarantined   bool   `json:"quarantined,omitempty"`
+}
+
+func (s *service) Export(ctx context.Context, w io.Writer) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	encoder := json.NewEncoder(w)
+
+	header := snapshotHeader{
+		Format:  snapshotFormat,
+		Kind:    s.kind,
+		Schema:  SchemaVersion,
+		Tenant:  sc.tenant,
+		Version: snapshotVersion,
+	}
+	err = encoder.Encode(header)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, name := range s.shards.All() {
+		shard := s.shards.Shard(name)
+
+		namespaces, err := shard.GetAllFromSet(sc.tableKey())
+		if err != nil {
+			return maskAny(err)
+		}
+
+		for _, namespace := range namespaces {
+			err := s.exportList(encoder, shard, sc, namespace, false)
+			if err != nil {
+				return maskAny(err)
+			}
+			err = s.exportList(encoder, shard, sc, namespace, true)
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// exportList writes the events queued within the given namespace, or the
+// events quarantined within it in case quarantined is true, to the given
+// encoder.
+func (s *service) exportList(encoder *json.Encoder, shard Storage, sc scope, namespace string, quarantined bool) error {
+	key := sc.namespaceKey(namespace)
+	if quarantined {
+		key = sc.quarantineKey(namespace)
+	}
+	eventIDs, err := shard.GetAllFromList(key)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	// The storage pushes new elements to the head of a list. The list is thus
+	// walked backwards to write the events in queue order.
+	for i := len(eventIDs) - 1; i >= 0; i-- {
+		ok, err := shard.Exists(sc.eventKey(eventIDs[i]))
+		if err != nil {
+			return maskAny(err)
+		}
+		if !ok {
+			// The event was already deleted. There is nothing to export.
+			continue
+		}
+		payload, err := shard.Get(sc.eventKey(eventIDs[i]))
+		if err != nil {
+			return maskAny(err)
+		}
+		payload, err = s.load(shard, sc, eventIDs[i], payload)
+		if err != nil {
+			return maskAny(err)
+		}
+
+		e := snapshotEvent{
+			CompactionKey: "",
+			ID:            eventIDs[i],
+			Namespace:     namespace,
+			Payload:       []byte(payload),
+			Quarantined:   quarantined,
+		}
+		if !quarantined {
+			e.CompactionKey, err = s.compactedBy(shard, sc, namespace, eventIDs[i])
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+		err = encoder.Encode(e)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) Import(ctx context.Context, r io.Reader) error {
+	err := s.bootError()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	sc, err := s.scope(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	for _, name := range s.shards.All() {
+		namespaces, err := s.shards.Shard(name).GetAllFromSet(sc.tableKey())
+		if err != nil {
+			return maskAny(err)
+		}
+		if len(namespaces) != 0 {
+			return maskAnyf(invalidExecutionError, "snapshot must only be imported into an empty service")
+		}
+	}
+
+	decoder := json.NewDecoder(r)
+
+	var header snapshotHeader
+	err = decoder.Decode(&header)
+	if err != nil {
+		return maskAny(err)
+	}
+	if header.Format != snapshotFormat {
+		return maskAnyf(invalidExecutionError, "snapshot format must be %s", snapshotFormat)
+	}
+	if header.Version < 1 || header.Version > snapshotVersion {
+		return maskAnyf(invalidExecutionError, "snapshot version must be between 1 and %d", snapshotVersion)
+	}
+	if header.Kind != s.kind {
+		return maskAnyf(invalidExecutionError, "snapshot kind must be %s", s.kind)
+	}
+	if header.Schema != SchemaVersion {
+		return maskAnyf(invalidSchemaError, "snapshot schema must be %d", SchemaVersion)
+	}
+
+	source := s.scopeOf(header.Tenant)
+	registered := map[string]bool{}
+	for {
+		var e snapshotEvent
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return maskAny(err)
+		}
+
+		shard := s.shardFor(sc, e.Namespace)
+
+		payload, err := s.rebind(source, sc, e.Namespace, e.ID, e.Payload)
+		if e.Quarantined && err != nil {
+			// Quarantined events might not be decodable at all. They are imported as
+			// they are, so that they can still be inspected.
+			payload = e.Payload
+		} else if err != nil {
+			return maskAny(err)
+		}
+
+		// The payload and the compaction key are written before the event ID is
+		// published, so consumers never pop an event without payload.
+		err = s.overwrite(shard, sc, e.ID, payload)
+		if err != nil {
+			return maskAny(err)
+		}
+		if e.Quarantined {
+			err = shard.PushToList(sc.quarantineKey(e.Namespace), e.ID)
+			if err != nil {
+				return maskAny(err)
+			}
+		} else {
+			err = s.restoreCompaction(shard, sc, e.Namespace, e.ID, e.CompactionKey)
+			if err != nil {
+				return maskAny(err)
+			}
+			err = shard.PushToList(sc.namespaceKey(e.Namespace), e.ID)
+			if err != nil {
+				return maskAny(err)
+			}
+		}
+		if !registered[e.Namespace] {
+			err = s.register(shard, sc, e.Namespace)
+			if err != nil {
+				return maskAny(err)
+			}
+			registered[e.Namespace] = true
+		}
+	}
+
+	return nil
+}
+
+// rebind moves the given stored representation of the event with the given ID
+// queued within the given namespace from the given source scope to the given
+// scope. Encryption and signatures cover the placement of an event, which is
+// why encrypted or signed events are decoded for the source scope and encoded
+// for the other one.
+func (s *service) rebind(source, sc scope, namespace, eventID string, b []byte) ([]byte, error) {
+	if source == sc {
+		return b, nil
+	}
+	_, _, encrypted := s.encryptedBy(b)
+	if !encrypted && len(s.signingSecret) == 0 {
+		return b, nil
+	}
+
+	e, err := s.decodeStored(source, namespace, eventID, b)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	b, err = s.marshal(sc, namespace, e)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	return b, nil
+}
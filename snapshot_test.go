@@ -0,0 +1,70 @@
+package event
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_Service_Snapshot_RoundTrip(t *testing.T) {
+	ma := newMemoryStorage()
+	a := newTestService(t, ma, func(config *ServiceConfig) {
+		config.CompactionMode = CompactionReplace
+	})
+	create(t, a,
+		newCompactionTestEvent(t, "1", "first", "k"),
+		newCompactionTestEvent(t, "2", "second", ""),
+		newCompactionTestEvent(t, "3", "third", ""),
+	)
+	err := a.Create(nil, newTestEvent(t, "4", "other"), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Event 2 failed to be consumed and was quarantined.
+	sc := a.scopeOf("")
+	ma.lists[sc.namespaceKey("a")] = []string{"3", "1"}
+	err = a.quarantine(a.shardFor(sc, "a"), sc, "a", "2", maskAnyf(invalidExecutionError, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = a.Export(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMemoryStorage()
+	b := newTestService(t, m, func(config *ServiceConfig) {
+		config.CompactionMode = CompactionReplace
+	})
+	err = b.Import(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The queues and the quarantine list are restored in order.
+	expected := map[string][]string{
+		sc.namespaceKey("a"):  {"3", "1"},
+		sc.namespaceKey("b"):  {"4"},
+		sc.quarantineKey("a"): {"2"},
+	}
+	for key, l := range expected {
+		if !reflect.DeepEqual(m.lists[key], l) {
+			t.Fatalf("expected list %s to be %v, got %v", key, l, m.lists[key])
+		}
+	}
+
+	// The compaction key of event 1 is restored, so it is replaced.
+	create(t, b, newCompactionTestEvent(t, "5", "new", "k"))
+	e := consume(t, b)
+	if e.ID() != "1" || e.Payload() != "new" {
+		t.Fatalf("expected event 1 with payload new, got %s with %s", e.ID(), e.Payload())
+	}
+
+	// Snapshots can only be imported into empty services.
+	err = b.Import(nil, &bytes.Buffer{})
+	if !IsInvalidExecution(err) {
+		t.Fatalf("expected invalid execution error, got %#v", err)
+	}
+}
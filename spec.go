@@ -2,10 +2,12 @@ package event
 
 import (
 	"encoding/json"
+	"io"
 	"reflect"
 	"time"
 
 	"github.com/the-anna-project/context"
+	"github.com/the-anna-project/storage"
 )
 
 // Backoff represents the object managing backoff algorithms to retry actions.
@@ -18,21 +20,131 @@ type Backoff interface {
 	Reset()
 }
 
+// Clock represents the source of the current time.
+type Clock interface {
+	// NewTicker returns a ticker delivering the time of the clock every time the
+	// given duration passed.
+	NewTicker(d time.Duration) Ticker
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// Codec represents the encoding of stored events.
+type Codec interface {
+	// Marshal encodes the given value.
+	Marshal(v interface{}) ([]byte, error)
+	// Name returns the name the codec is recorded with in every stored event it
+	// encoded. Names must be unique and must not contain ':'.
+	Name() string
+	// Unmarshal decodes the given bytes into the given value.
+	Unmarshal(b []byte, v interface{}) error
+}
+
 type Event interface {
 	Created() time.Time
+	// Headers returns the metadata describing the event and its payload. The
+	// returned map is shared with the event and must not be modified.
+	Headers() map[string]string
 	ID() string
 	json.Marshaler
 	json.Unmarshaler
 	Payload() string
+	// PayloadBytes returns the payload without converting it to a string. The
+	// returned slice is shared with the event and must not be modified. Payloads
+	// are not required to be valid UTF-8.
+	PayloadBytes() []byte
+}
+
+// FakeClock represents a clock controlled by tests.
+type FakeClock interface {
+	Clock
+	// Advance moves the time of the clock forward by the given duration. The
+	// tickers of the clock deliver a tick in case their duration passed.
+	Advance(d time.Duration)
+	// Set sets the time of the clock. The tickers of the clock deliver a tick in
+	// case their duration passed.
+	Set(now time.Time)
+}
+
+// Future represents the result of an asynchronous operation.
+type Future interface {
+	// Done returns a channel which is closed as soon as the operation finished.
+	Done() <-chan struct{}
+	// Err blocks until the operation finished and returns its error, if any.
+	Err() error
+}
+
+// IDGenerator represents the source of event IDs.
+type IDGenerator interface {
+	// New returns a new unique ID.
+	New() (string, error)
 }
 
+// Keyring represents the keys used to encrypt stored events using AES-GCM.
+type Keyring struct {
+	// Active is the ID of the key encrypting new events. Stored events are not
+	// encrypted in case Active is empty.
+	Active string
+	// Keys maps key IDs to AES keys of 16, 24 or 32 bytes. Keys have to be kept
+	// as long as events encrypted by them are stored. See Service.Reencrypt.
+	Keys map[string][]byte
+}
+
+// Migration represents a function upgrading the data stored by an event service
+// of the given kind from one schema version to the next one.
+type Migration func(storage Storage, keyPrefix, kind string) error
+
 type Service interface {
 	// Boot initializes and starts the whole service like booting a machine. The
 	// call to Boot blocks until the service is completely initialized, so you
 	// might want to call it in a separate goroutine.
+	//
+	// Boot verifies the schema version stored for the service's kind and runs
+	// the registered migrations in case the stored version is outdated. Storage
+	// failures are retried using ServiceConfig.BackoffService. In case the
+	// stored data cannot be migrated, the service refuses to operate and all
+	// upcoming calls return the error, e.g. an invalid schema error, until Boot
+	// is called again and succeeds.
 	Boot()
+	// ClearQuarantine removes the events quarantined within the namespace of the
+	// given labels together with their stored representations. See
+	// ServiceConfig.QuarantineTampered.
+	ClearQuarantine(ctx context.Context, labels ...string) error
+	// CompareMirror compares the data of the primary and the secondary storage
+	// configured by ServiceConfig.SecondaryStorageCollection and returns the
+	// keys holding different data. The queues, quarantines and payloads of all
+	// namespaces of all tenants are compared. Every diverged key is reported to
+	// ServiceConfig.DivergenceNotifier as well. Keys are compared one after the
+	// other, so mutations applied while comparing might be reported as
+	// divergence.
+	CompareMirror(ctx context.Context) ([]string, error)
+	// Failback switches back to the primary storage configured by
+	// ServiceConfig.StorageCollection after the service failed over to the
+	// secondary storage. The primary storage has to be resynchronized with the
+	// secondary storage before, because it missed the mutations applied while
+	// the service was failed over. Failback compares both storages like
+	// Service.CompareMirror and fails in case any key diverged. Mutations are
+	// blocked while the storages are compared. Failing over and back is local to
+	// the process, so every process using the storages has to fail back on its
+	// own.
+	Failback(ctx context.Context) error
 	// Create publishes the given event and associates it with the given labels.
+	// In case the event carries a HeaderCompactionKey header, only the newest
+	// event per compaction key is retained within the namespace of the given
+	// labels. Depending on ServiceConfig.CompactionMode, an older event with the
+	// same key that is still queued is either replaced by the given event in its
+	// queue position, or dropped from its queue. A replacing event takes over the
+	// ID of the replaced event, because queues reference events by their IDs.
+	// Producers relying on the IDs of their events use CompactionDrop instead.
+	// In case the replaced event is consumed while it is replaced, the given
+	// event is published under its own ID, so consumers might receive it twice.
 	Create(ctx context.Context, event Event, labels ...string) error
+	// CreateAsync publishes the given event like Service.Create and returns a
+	// future resolved as soon as the event is written. In the buffered producer
+	// mode configured by ServiceConfig.BatchSize, the event is written with the
+	// next flush, which is when it is compacted as well. Otherwise the event is
+	// written before CreateAsync returns.
+	CreateAsync(ctx context.Context, event Event, labels ...string) Future
 	// Delete removes the given event which is associated with the given labels.
 	//
 	// Delete does not unqueue events. That is why delete must be called on an
@@ -43,12 +155,48 @@ type Service interface {
 	// ExistsAny checks whether there is any event queued associated within the
 	// given labels.
 	ExistsAny(ctx context.Context, labels ...string) (bool, error)
+	// Export writes the state of the tenant of the given context to the given
+	// writer. Other tenants of the service's kind are not exported. The snapshot
+	// is a versioned stream of newline delimited JSON objects holding every
+	// namespace of the tenant, its queue order, its quarantined events, the
+	// compaction keys of its queued events and all payloads.
+	Export(ctx context.Context, w io.Writer) error
+	// Import restores the state written by Service.Export for the tenant of the
+	// given context. Import must only be called for a tenant that does not hold
+	// any event yet. Encrypted and signed events exported from another tenant
+	// are encrypted and signed again for the importing tenant, which requires the
+	// keyring to hold the keys they were encrypted with.
+	Import(ctx context.Context, r io.Reader) error
 	// Limit trims the number of events within a labeled queue by cutting off
 	// events from the queue's tail.
 	Limit(ctx context.Context, max int, labels ...string) error
+	// Quarantined returns the IDs of the events quarantined within the namespace
+	// of the given labels in the order they were quarantined. Quarantined events
+	// cannot be consumed, because they failed verification or could not be
+	// loaded, decoded or upcast. Their stored
+	// representations are kept under their storage keys for inspection until
+	// Service.ClearQuarantine is called.
+	Quarantined(ctx context.Context, labels ...string) ([]string, error)
+	// Rebalance moves every namespace to the shard owning it according to the
+	// current shard configuration. It has to be called after shards were added to
+	// or removed from ServiceConfig.StorageShards. The storage collections of
+	// removed shards have to be given as retired, so that their namespaces are
+	// moved to the remaining shards.
+	Rebalance(ctx context.Context, retired ...*storage.Collection) error
+	// Reencrypt rewrites all stored events that are not encrypted with the
+	// active key of the configured keyring, e.g. after a key rotation. Retired
+	// keys can be removed from the keyring afterwards. Events stored unencrypted
+	// are encrypted as well, after which ServiceConfig.AcceptUnencrypted can be
+	// unset.
+	Reencrypt(ctx context.Context) error
 	// Search blocks until the next event associated with the given labels can be
 	// returned. Consuming any event regardless their labeling can be done by
 	// providing the wildcard label LabelWildcard.
+	//
+	// A consumed event that cannot be returned, e.g. because it is tampered, its
+	// payload cannot be decoded or an upcaster fails, is not consumed again.
+	// Search returns the error and moves the event to the quarantine of its
+	// namespace. See Service.Quarantined.
 	Search(ctx context.Context, labels ...string) (Event, error)
 	// SearchAll returns all events associated with the given labels. While
 	// Service.Search blocks until one event is available and can be returned,
@@ -57,13 +205,168 @@ type Service interface {
 	SearchAll(ctx context.Context, labels ...string) ([]Event, error)
 	// Shutdown ends all processes of the service like shutting down a machine.
 	// The call to Shutdown blocks until the service is completely shut down, so
-	// you might want to call it in a separate goroutine.
+	// you might want to call it in a separate goroutine. Events buffered in the
+	// buffered producer mode are flushed before Shutdown returns.
 	Shutdown()
 	// WriteAll overwrites all events associated with the provided labels with the
 	// given list of events, no matter if there have been events before or not.
 	WriteAll(ctx context.Context, events []Event, labels ...string) error
 }
 
+// Counter is implemented by storages able to atomically increment integers.
+// The event service falls back to reading and writing the integer using
+// Storage.Get and Storage.Set for storages not implementing it, which is not
+// atomic.
+type Counter interface {
+	// IncrementBy adds the given delta to the integer stored under the given key
+	// and returns the result. A missing key holds 0.
+	IncrementBy(key string, delta int) (int, error)
+}
+
+// ListAppender is implemented by storages able to push elements to the end of
+// a list, which holds the oldest elements. The event service falls back to
+// rebuilding the list for storages not implementing it. Elements pushed or
+// popped by other processes while the list is rebuilt are then lost or
+// duplicated.
+type ListAppender interface {
+	// AppendManyToList pushes the given elements to the end of the given list.
+	// The elements are given in the order of the list, so that the last element
+	// is popped first.
+	AppendManyToList(key string, elements []string) error
+}
+
+// ListEvicter is implemented by storages able to atomically remove the oldest
+// elements of a list. The event service falls back to reading and trimming the
+// list for storages not implementing it. Elements pushed in between then push
+// out the same number of additional old elements, which are neither archived
+// nor cleaned up.
+type ListEvicter interface {
+	// EvictFromList removes up to the given number of elements from the end of
+	// the given list, which holds the oldest elements, and returns them in the
+	// order of the list.
+	EvictFromList(key string, n int) ([]string, error)
+}
+
+// ListLengther is implemented by storages able to return the length of a list
+// without fetching its elements. The event service falls back to
+// Storage.GetAllFromList for storages not implementing it.
+type ListLengther interface {
+	// LengthOfList returns the number of elements of the given list. A missing
+	// list has no elements.
+	LengthOfList(key string) (int, error)
+}
+
+// MultiGetter is implemented by storages able to fetch the values of multiple
+// keys within a single round trip. The event service falls back to concurrent
+// calls to Storage.Get for storages not implementing it, like the event storage
+// of storage.Collection. Their round trips overlap, but every key still costs a
+// round trip of its own.
+type MultiGetter interface {
+	// GetMany returns the values of the given keys in the order of the keys. In
+	// case any key does not exist, a not found error is returned.
+	GetMany(keys []string) ([]string, error)
+}
+
+// MultiPusher is implemented by storages able to push multiple elements to a
+// list within a single round trip. The event service falls back to one call to
+// Storage.PushToList per element for storages not implementing it.
+type MultiPusher interface {
+	// PushManyToList pushes the given elements to the head of the given list in
+	// the order of the elements, so that the first element is popped first.
+	PushManyToList(key string, elements []string) error
+}
+
+// Quota represents the limits of the resources a tenant can use. Limits set to
+// their zero value are not enforced. Operations exceeding a limit fail with a
+// quota exceeded error.
+type Quota struct {
+	// MaxQueueDepth is the maximum number of events queued within a single
+	// namespace. Enforcing it costs an additional storage round trip for every
+	// created event. The depth is checked before an event is queued, so
+	// concurrent calls may exceed it by the number of concurrent callers.
+	MaxQueueDepth int
+	// MaxStoredBytes is the maximum total size of all payloads stored for a
+	// tenant. The stored bytes are tracked in a counter, which costs additional
+	// storage round trips for every written and removed payload. Only payloads
+	// written while the limit is enforced are tracked.
+	MaxStoredBytes int
+}
+
+// RetentionPolicy represents the rules limiting the events retained within the
+// namespaces matching the policy's pattern. Limits set to their zero value are
+// not enforced. Events violating any limit are evicted oldest first.
+type RetentionPolicy struct {
+	// Pattern is matched against namespaces using the syntax of path.Match. A
+	// namespace is the concatenation of an event's sorted labels.
+	Pattern string
+	// MaxAge is the maximum age of an event based on Event.Created. Events
+	// lacking a creation time age from the time retention saw them first.
+	MaxAge time.Duration
+	// MaxBytes is the maximum total size of all stored payloads.
+	MaxBytes int
+	// MaxCount is the maximum number of queued events.
+	MaxCount int
+}
+
+// SchemaRegistry represents the registry of the JSON Schemas event payloads
+// are validated against.
+type SchemaRegistry interface {
+	// RegisterLabels registers the given JSON Schema for the payloads of events
+	// created with the given labels, regardless of their order.
+	RegisterLabels(schema string, labels ...string) error
+	// RegisterType registers the given JSON Schema for the payloads of events
+	// whose HeaderType header is the given event type. Schemas registered by
+	// type take precedence over schemas registered by labels.
+	RegisterType(eventType string, schema string) error
+	// Validate validates the payload of the given event created with the given
+	// labels. Payloads not covered by any registered schema are valid. Invalid
+	// payloads cause an invalid payload error.
+	Validate(event Event, labels ...string) error
+}
+
+// Ticker represents a ticker created by Clock.NewTicker. Like time.Ticker, a
+// ticker drops ticks in case its receiver is not ready.
+type Ticker interface {
+	// C returns the channel the ticks are delivered on.
+	C() <-chan time.Time
+	// Stop turns off the ticker. No more ticks are delivered afterwards.
+	Stop()
+}
+
+// Upcaster transforms the payload of an event from one version of its type to
+// the next one.
+type Upcaster func(payload []byte) ([]byte, error)
+
+// UpcasterRegistry represents the registry of the upcasters bringing events to
+// the latest version of their type.
+type UpcasterRegistry interface {
+	// Register registers the given upcaster transforming the payloads of events
+	// of the given type from the given version to the next one.
+	Register(eventType string, version int, upcaster Upcaster) error
+	// Upcast returns the given event in the latest version of its type. The
+	// type and version of an event are described by its HeaderType and
+	// HeaderSchemaVersion headers. Upcasters are applied one version after the
+	// other, until there is no upcaster registered for the current version.
+	Upcast(event Event) (Event, error)
+}
+
+// Storage represents the subset of the storage service the event service makes
+// use of. The event storage of a storage collection satisfies it.
+type Storage interface {
+	Exists(key string) (bool, error)
+	Get(key string) (string, error)
+	GetAllFromList(key string) ([]string, error)
+	GetAllFromSet(key string) ([]string, error)
+	GetRandomFromSet(key string) (string, error)
+	PopFromList(key string) (string, error)
+	PushToList(key string, element string) error
+	PushToSet(key string, element string) error
+	Remove(key string) error
+	RemoveFromSet(key string, element string) error
+	Set(key, value string) error
+	TrimEndOfList(key string, maxElements int) error
+}
+
 type Signal interface {
 	Arguments() []reflect.Value
 	Context() context.Context
@@ -0,0 +1,188 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/the-anna-project/context"
+)
+
+// scope represents the storage keys of a single tenant of the service's kind.
+// The default tenant, which is the empty string, uses the key layout of
+// services without tenants, so that existing data keeps being accessible.
+type scope struct {
+	prefix string
+	tenant string
+}
+
+// release releases the stored bytes added by checkQuota for a payload that was
+// not stored because of the given error, which is returned.
+func (s *service) release(sc scope, size int, err error) error {
+	rerr := s.account(sc, -size)
+	if rerr != nil {
+		return maskAny(rerr)
+	}
+
+	return maskAny(err)
+}
+
+// scope returns the scope of the tenant the given context belongs to. The
+// tenant is looked up using the configured tenant function. In case it does
+// not provide a tenant, the configured tenant is used.
+func (s *service) scope(ctx context.Context) (scope, error) {
+	tenant := s.tenant
+	if s.tenantFunc != nil {
+		t, err := s.tenantFunc(ctx)
+		if err != nil {
+			return scope{}, maskAny(err)
+		}
+		if t != "" {
+			tenant = t
+		}
+	}
+	if strings.Contains(tenant, ":") {
+		return scope{}, maskAnyf(invalidContextError, "tenant must not contain ':'")
+	}
+
+	return s.scopeOf(tenant), nil
+}
+
+// scopeOf returns the scope of the given tenant.
+func (s *service) scopeOf(tenant string) scope {
+	if tenant == "" {
+		return scope{prefix: fmt.Sprintf("%s:kind:%s", s.keyPrefix, s.kind), tenant: ""}
+	}
+
+	return scope{prefix: fmt.Sprintf("%s:tenant:%s:kind:%s", s.keyPrefix, tenant, s.kind), tenant: tenant}
+}
+
+// scopes returns the scopes of all tenants having data within the given shard.
+func (s *service) scopes(shard Storage) ([]scope, error) {
+	tenants, err := shard.GetAllFromSet(s.tenantsKey())
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	scopes := []scope{s.scopeOf("")}
+	for _, t := range tenants {
+		scopes = append(scopes, s.scopeOf(t))
+	}
+
+	return scopes, nil
+}
+
+// account adds the given delta to the stored bytes of the scope's tenant, in
+// case its quota limits them.
+func (s *service) account(sc scope, delta int) error {
+	if s.quota(sc).MaxStoredBytes == 0 || delta == 0 {
+		return nil
+	}
+
+	_, err := incrementBy(s.bytesShard(sc), sc.storedBytesKey(), delta)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// bytesShard returns the shard holding the stored bytes of the scope's tenant.
+// The counter is shared across all namespaces of the tenant.
+func (s *service) bytesShard(sc scope) Storage {
+	return s.shards.Shard(s.shards.Owner(sc.storedBytesKey()))
+}
+
+// checkQuota verifies that queueing the given stored payload in the given
+// namespace does not exceed the quota of the scope's tenant. The bytes of the
+// payload are added to the stored bytes of the tenant right away, so that
+// concurrent calls cannot exceed the limit. Callers have to release them using
+// account in case the payload is not stored eventually.
+func (s *service) checkQuota(shard Storage, sc scope, namespace string, b []byte) error {
+	quota := s.quota(sc)
+
+	if quota.MaxQueueDepth > 0 {
+		n, err := listLength(shard, sc.namespaceKey(namespace))
+		if err != nil {
+			return maskAny(err)
+		}
+		if n >= quota.MaxQueueDepth {
+			return maskAnyf(quotaExceededError, "queue depth of %d reached", quota.MaxQueueDepth)
+		}
+	}
+
+	if quota.MaxStoredBytes > 0 {
+		n, err := incrementBy(s.bytesShard(sc), sc.storedBytesKey(), len(b))
+		if err != nil {
+			return maskAny(err)
+		}
+		if n > quota.MaxStoredBytes {
+			_, err := incrementBy(s.bytesShard(sc), sc.storedBytesKey(), -len(b))
+			if err != nil {
+				return maskAny(err)
+			}
+			return maskAnyf(quotaExceededError, "payload of %d bytes exceeds %d stored bytes", len(b), quota.MaxStoredBytes)
+		}
+	}
+
+	return nil
+}
+
+// quota returns the quota of the scope's tenant.
+func (s *service) quota(sc scope) Quota {
+	quota, ok := s.tenantQuotas[sc.tenant]
+	if !ok {
+		quota = s.tenantQuota
+	}
+
+	return quota
+}
+
+// register adds the given namespace to the lookup table of the given scope.
+// Tenants other than the default tenant are registered as well, so that
+// background workers find their data. Duplicated elements will be ignored so we
+// can simply fire and forget.
+func (s *service) register(shard Storage, sc scope, namespace string) error {
+	if sc.tenant != "" {
+		err := shard.PushToSet(s.tenantsKey(), sc.tenant)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	err := shard.PushToSet(sc.tableKey(), namespace)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// redis set
+// holding all tenants other than the default tenant
+func (s *service) tenantsKey() string {
+	return fmt.Sprintf("%s:kind:%s:tenants", s.keyPrefix, s.kind)
+}
+
+func (c scope) eventKey(eventID string) string {
+	return fmt.Sprintf("%s:event:%s", c.prefix, eventID)
+}
+
+// redis list
+// holding events
+func (c scope) namespaceKey(namespace string) string {
+	return fmt.Sprintf("%s:namespace:%s", c.prefix, namespace)
+}
+
+// redis string
+// holding the total size of all payloads stored for the tenant
+func (c scope) storedBytesKey() string {
+	return fmt.Sprintf("%s:bytes", c.prefix)
+}
+
+// redis set
+// holding all namespaces
+// random member
+// check existence
+func (c scope) tableKey() string {
+	return fmt.Sprintf("%s:table", c.prefix)
+}
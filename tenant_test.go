@@ -0,0 +1,109 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/the-anna-project/storage"
+)
+
+// testStoredSize returns the size of the stored representation of the given
+// event.
+func testStoredSize(t *testing.T, s *service, e Event) int {
+	b, err := s.marshal(s.scopeOf(""), "a", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(b)
+}
+
+func Test_Service_Quota_MaxStoredBytes(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		m := newMemoryStorage()
+		var st storage.Service = m
+		if bulk {
+			st = bulkStorage{m}
+		}
+		size := testStoredSize(t, newTestService(t, newMemoryStorage(), nil), newTestEvent(t, "1", "payload"))
+		s := newTestService(t, st, func(config *ServiceConfig) {
+			config.TenantQuota = Quota{MaxStoredBytes: 2 * size}
+		})
+
+		for _, eventID := range []string{"1", "2"} {
+			err := s.Create(nil, newTestEvent(t, eventID, "payload"), "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := s.Create(nil, newTestEvent(t, "3", "payload"), "a")
+		if !IsQuotaExceeded(err) {
+			t.Fatalf("bulk %t: expected quota exceeded error, got %#v", bulk, err)
+		}
+
+		// Consuming an event frees its bytes.
+		e, err := s.Search(nil, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Delete(nil, e, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Create(nil, newTestEvent(t, "3", "payload"), "a")
+		if err != nil {
+			t.Fatalf("bulk %t: %s", bulk, err)
+		}
+	}
+}
+
+func Test_Service_Quota_MaxStoredBytes_Concurrent(t *testing.T) {
+	m := newMemoryStorage()
+	size := testStoredSize(t, newTestService(t, newMemoryStorage(), nil), newTestEvent(t, "10", "payload"))
+	s := newTestService(t, bulkStorage{m}, func(config *ServiceConfig) {
+		config.TenantQuota = Quota{MaxStoredBytes: 5 * size}
+	})
+
+	var created int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 10; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			err := s.Create(nil, newTestEvent(t, fmt.Sprintf("%d", i), "payload"), "a")
+			if err == nil {
+				mutex.Lock()
+				created++
+				mutex.Unlock()
+			} else if !IsQuotaExceeded(err) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 5 {
+		t.Fatalf("expected 5 created events, got %d", created)
+	}
+}
+
+func Test_Service_Quota_MaxQueueDepth(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, bulkStorage{m}, func(config *ServiceConfig) {
+		config.TenantQuota = Quota{MaxQueueDepth: 2}
+	})
+
+	for _, eventID := range []string{"1", "2"} {
+		err := s.Create(nil, newTestEvent(t, eventID, "payload"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.Create(nil, newTestEvent(t, "3", "payload"), "a")
+	if !IsQuotaExceeded(err) {
+		t.Fatalf("expected quota exceeded error, got %#v", err)
+	}
+}
@@ -0,0 +1,350 @@
+//go:build go1.18
+// +build go1.18
+
+package event
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/the-anna-project/context"
+)
+
+// TypedEvent represents an event consumed by a TypedService together with its
+// decoded payload.
+type TypedEvent[T any] struct {
+	// Event is the consumed event, e.g. to be deleted using Service.Delete.
+	Event Event
+	// Value is the decoded payload of Event.
+	Value T
+}
+
+// TypedService represents a Service queueing values of type T. Values are
+// encoded into the payloads of the created events and decoded from the
+// payloads of the consumed events using a pluggable codec. Payloads that cannot
+// be decoded cause a *DecodeError.
+type TypedService[T any] interface {
+	// Create creates a new event carrying the given value. See Service.Create.
+	Create(ctx context.Context, value T, labels ...string) error
+	// Search consumes an event and decodes its value. See Service.Search.
+	Search(ctx context.Context, labels ...string) (TypedEvent[T], error)
+	// SearchAll returns all queued events and their decoded values. See
+	// Service.SearchAll.
+	SearchAll(ctx context.Context, labels ...string) ([]TypedEvent[T], error)
+	// Subscribe consumes events one after the other using Search and hands them
+	// to the given handler. Subscribe returns as soon as the handler or Search
+	// return an error, which is then returned by Subscribe. Not found errors of
+	// Search, e.g. caused by a wildcard Search finding no event, are not
+	// returned, but Search is called again after
+	// TypedServiceConfig.PollInterval. Events whose payload cannot be decoded
+	// are handled as configured by TypedServiceConfig.SkipUndecodable and
+	// TypedServiceConfig.DeadLetterLabels. Subscribe returns nil once the given
+	// stop channel is closed, and the error of the given context once it is
+	// cancelled. A Search in progress is not interrupted, and the event it
+	// returns is still handed to the handler.
+	Subscribe(ctx context.Context, stop <-chan struct{}, handler func(e TypedEvent[T]) error, labels ...string) error
+}
+
+// TypedServiceConfig represents the configuration used to create a new typed
+// event service.
+type TypedServiceConfig struct {
+	// Dependencies.
+	// Clock provides the creation time of created events and the ticks Subscribe
+	// polls with.
+	Clock Clock
+	// IDGenerator provides the IDs of created events.
+	IDGenerator IDGenerator
+	Service     Service
+
+	// Settings.
+	// Codec encodes values into payloads and decodes them from payloads. It is
+	// independent of the codec the service stores events with.
+	Codec Codec
+	// DeadLetterLabels optionally configures Subscribe to move events whose
+	// payload cannot be decoded to the queue of the given labels, instead of
+	// returning the decode error. The moved event is a copy carrying a new ID.
+	DeadLetterLabels []string
+	// PollInterval is the time Subscribe waits before it searches again after
+	// Search found no event.
+	PollInterval time.Duration
+	// SkipUndecodable configures Subscribe to skip events whose payload cannot
+	// be decoded, instead of returning the decode error.
+	//
+	// Skipped and dead lettered events are deleted. Events consumed using the
+	// wildcard label are deleted from the namespace they were consumed from, in
+	// case the service tells it. Otherwise they are left behind.
+	SkipUndecodable bool
+}
+
+// DefaultTypedServiceConfig provides a default configuration to create a new
+// typed event service by best effort. The service has to be configured by the
+// caller.
+func DefaultTypedServiceConfig() TypedServiceConfig {
+	idGenerator, err := defaultIDGenerator()
+	if err != nil {
+		panic(err)
+	}
+
+	config := TypedServiceConfig{
+		// Dependencies.
+		Clock:       NewClock(),
+		IDGenerator: idGenerator,
+		Service:     nil,
+
+		// Settings.
+		Codec:            NewJSONCodec(),
+		DeadLetterLabels: nil,
+		PollInterval:     100 * time.Millisecond,
+		SkipUndecodable:  false,
+	}
+
+	return config
+}
+
+// NewTypedService creates a new configured typed event service.
+func NewTypedService[T any](config TypedServiceConfig) (TypedService[T], error) {
+	// Dependencies.
+	if config.Clock == nil {
+		return nil, maskAnyf(invalidConfigError, "clock must not be empty")
+	}
+	if config.IDGenerator == nil {
+		return nil, maskAnyf(invalidConfigError, "id generator must not be empty")
+	}
+	if config.Service == nil {
+		return nil, maskAnyf(invalidConfigError, "service must not be empty")
+	}
+
+	// Settings.
+	if config.Codec == nil {
+		return nil, maskAnyf(invalidConfigError, "codec must not be empty")
+	}
+	if len(config.DeadLetterLabels) != 0 && config.SkipUndecodable {
+		return nil, maskAnyf(invalidConfigError, "dead letter labels must not be combined with skipping undecodable events")
+	}
+	for _, l := range config.DeadLetterLabels {
+		if l == LabelWildcard {
+			return nil, maskAnyf(invalidConfigError, "dead letter labels must not contain the wildcard label")
+		}
+	}
+	if config.PollInterval <= 0 {
+		return nil, maskAnyf(invalidConfigError, "poll interval must be greater than 0")
+	}
+
+	newService := &typedService[T]{
+		// Dependencies.
+		clock:       config.Clock,
+		idGenerator: config.IDGenerator,
+		service:     config.Service,
+
+		// Settings.
+		codec:            config.Codec,
+		deadLetterLabels: config.DeadLetterLabels,
+		pollInterval:     config.PollInterval,
+		skipUndecodable:  config.SkipUndecodable,
+	}
+
+	return newService, nil
+}
+
+// namespaceSearcher is implemented by services telling the namespace an event
+// consumed by Service.Search was queued within, which the wildcard label does
+// not tell.
+type namespaceSearcher interface {
+	search(ctx context.Context, labels ...string) (Event, string, error)
+}
+
+type typedService[T any] struct {
+	// Dependencies.
+	clock       Clock
+	idGenerator IDGenerator
+	service     Service
+
+	// Settings.
+	codec            Codec
+	deadLetterLabels []string
+	pollInterval     time.Duration
+	skipUndecodable  bool
+}
+
+func (s *typedService[T]) Create(ctx context.Context, value T, labels ...string) error {
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return maskAnyf(invalidPayloadError, "value must be encodable by codec %s: %s", s.codec.Name(), err)
+	}
+
+	newEvent, err := NewEvent(
+		WithClock(s.clock),
+		WithIDGenerator(s.idGenerator),
+		WithPayloadBytes(b),
+	)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	err = s.service.Create(ctx, newEvent, labels...)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+func (s *typedService[T]) Search(ctx context.Context, labels ...string) (TypedEvent[T], error) {
+	var e Event
+	var namespace string
+	var err error
+	if searcher, ok := s.service.(namespaceSearcher); ok {
+		e, namespace, err = searcher.search(ctx, labels...)
+	} else {
+		e, err = s.service.Search(ctx, labels...)
+	}
+	if err != nil {
+		return TypedEvent[T]{}, maskAny(err)
+	}
+
+	t, err := s.decode(e)
+	if IsDecode(err) {
+		errgo.Cause(err).(*DecodeError).Namespace = namespace
+		return TypedEvent[T]{}, maskAny(err)
+	} else if err != nil {
+		return TypedEvent[T]{}, maskAny(err)
+	}
+
+	return t, nil
+}
+
+func (s *typedService[T]) SearchAll(ctx context.Context, labels ...string) ([]TypedEvent[T], error) {
+	events, err := s.service.SearchAll(ctx, labels...)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	var typed []TypedEvent[T]
+	for _, e := range events {
+		t, err := s.decode(e)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		typed = append(typed, t)
+	}
+
+	return typed, nil
+}
+
+func (s *typedService[T]) Subscribe(ctx context.Context, stop <-chan struct{}, handler func(e TypedEvent[T]) error, labels ...string) error {
+	ticker := s.clock.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	cancelled := doneOf(ctx)
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-cancelled:
+			return maskAny(ctx.Err())
+		default:
+		}
+
+		t, err := s.Search(ctx, labels...)
+		if IsNotFound(err) {
+			select {
+			case <-stop:
+				return nil
+			case <-cancelled:
+				return maskAny(ctx.Err())
+			case <-ticker.C():
+			}
+			continue
+		} else if IsDecode(err) {
+			err := s.undecodable(ctx, err, labels...)
+			if err != nil {
+				return maskAny(err)
+			}
+			continue
+		} else if err != nil {
+			return maskAny(err)
+		}
+
+		err = handler(t)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+}
+
+// undecodable handles the given decode error of an event consumed from the
+// queue of the given labels. The event is either skipped or moved to the dead
+// letter queue, as configured. Otherwise the decode error is returned.
+func (s *typedService[T]) undecodable(ctx context.Context, err error, labels ...string) error {
+	if !s.skipUndecodable && len(s.deadLetterLabels) == 0 {
+		return maskAny(err)
+	}
+	decodeErr := errgo.Cause(err).(*DecodeError)
+	e := decodeErr.Event
+
+	if len(s.deadLetterLabels) != 0 {
+		deadLetter, err := NewEvent(
+			WithClock(s.clock),
+			WithCreated(e.Created()),
+			WithHeaders(e.Headers()),
+			WithIDGenerator(s.idGenerator),
+			WithPayloadBytes(e.PayloadBytes()),
+		)
+		if err != nil {
+			return maskAny(err)
+		}
+		err = s.service.Create(ctx, deadLetter, s.deadLetterLabels...)
+		if err != nil {
+			return maskAny(err)
+		}
+	}
+
+	// The namespace of an event consumed using the wildcard label is the
+	// concatenation of its sorted labels, so it addresses the event as single
+	// label.
+	if len(labels) == 1 && labels[0] == LabelWildcard {
+		if decodeErr.Namespace == "" {
+			return nil
+		}
+		labels = []string{decodeErr.Namespace}
+	}
+	err = s.service.Delete(ctx, e, labels...)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	return nil
+}
+
+// decode decodes the value carried by the given event.
+func (s *typedService[T]) decode(e Event) (TypedEvent[T], error) {
+	var value T
+	err := s.codec.Unmarshal(e.PayloadBytes(), &value)
+	if err != nil {
+		return TypedEvent[T]{}, maskAny(&DecodeError{
+			Codec: s.codec.Name(),
+			Err:   err,
+			Event: e,
+			Type:  reflect.TypeOf(&value).Elem().String(),
+		})
+	}
+
+	t := TypedEvent[T]{
+		Event: e,
+		Value: value,
+	}
+
+	return t, nil
+}
+
+// doneOf returns the channel closed once the given context is cancelled. Empty
+// contexts are never cancelled, so their channel is nil and blocks forever.
+func doneOf(ctx context.Context) <-chan struct{} {
+	if ctx == nil {
+		return nil
+	}
+
+	return ctx.Done()
+}
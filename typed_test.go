@@ -0,0 +1,247 @@
+//go:build go1.18
+// +build go1.18
+
+package event
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/the-anna-project/context"
+)
+
+var errCancelled = errors.New("cancelled")
+
+// cancelContext is a context cancelled by closing its done channel.
+type cancelContext struct {
+	context.Context
+
+	done chan struct{}
+}
+
+func (c *cancelContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *cancelContext) Err() error {
+	select {
+	case <-c.done:
+		return errCancelled
+	default:
+		return nil
+	}
+}
+
+// newTestTypedService creates a typed service of strings on top of the given
+// service. The given function may modify the configuration beforehand.
+func newTestTypedService(t *testing.T, s Service, f func(config *TypedServiceConfig)) TypedService[string] {
+	config := DefaultTypedServiceConfig()
+	config.Clock = NewFakeClock(time.Unix(5, 0).UTC())
+	config.IDGenerator = NewFakeIDGenerator("typed")
+	config.Service = s
+	if f != nil {
+		f(&config)
+	}
+
+	newService, err := NewTypedService[string](config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newService
+}
+
+func Test_TypedService_Create(t *testing.T) {
+	s := newTestService(t, newMemoryStorage(), nil)
+	typed := newTestTypedService(t, s, nil)
+
+	err := typed.Create(nil, "value", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := s.Search(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() != "typed-1" {
+		t.Fatalf("expected event typed-1, got %s", e.ID())
+	}
+	if !e.Created().Equal(time.Unix(5, 0)) {
+		t.Fatalf("expected event created at the time of the clock, got %s", e.Created())
+	}
+}
+
+func Test_TypedService_Subscribe_Stop(t *testing.T) {
+	s := newTestService(t, newMemoryStorage(), nil)
+	typed := newTestTypedService(t, s, nil)
+
+	for _, v := range []string{"a", "b"} {
+		err := typed.Create(nil, v, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var values []string
+	err := typed.Subscribe(nil, stop, func(e TypedEvent[string]) error {
+		values = append(values, e.Value)
+		if len(values) == 2 {
+			close(stop)
+		}
+		return nil
+	}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Fatalf("expected values a and b, got %#v", values)
+	}
+
+	// Stopping also ends waiting for events of an empty queue.
+	stop = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- typed.Subscribe(nil, stop, func(e TypedEvent[string]) error { return nil }, "a")
+	}()
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Subscribe to return once stopped")
+	}
+}
+
+func Test_TypedService_Subscribe_Undecodable(t *testing.T) {
+	testCases := []struct {
+		DeadLetterLabels []string
+		SkipUndecodable  bool
+	}{
+		{DeadLetterLabels: nil, SkipUndecodable: false},
+		{DeadLetterLabels: nil, SkipUndecodable: true},
+		{DeadLetterLabels: []string{"dead"}, SkipUndecodable: false},
+	}
+
+	for i, tc := range testCases {
+		m := newMemoryStorage()
+		s := newTestService(t, m, nil)
+		typed := newTestTypedService(t, s, func(config *TypedServiceConfig) {
+			config.DeadLetterLabels = tc.DeadLetterLabels
+			config.SkipUndecodable = tc.SkipUndecodable
+		})
+
+		err := s.Create(nil, newTestEvent(t, "1", "undecodable"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = typed.Create(nil, "value", "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stop := make(chan struct{})
+		var values []string
+		err = typed.Subscribe(nil, stop, func(e TypedEvent[string]) error {
+			values = append(values, e.Value)
+			close(stop)
+			return nil
+		}, "a")
+
+		if !tc.SkipUndecodable && len(tc.DeadLetterLabels) == 0 {
+			if !IsDecode(err) {
+				t.Fatalf("test case %d: expected decode error, got %#v", i+1, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test case %d: %#v", i+1, err)
+		}
+		if len(values) != 1 || values[0] != "value" {
+			t.Fatalf("test case %d: expected value, got %#v", i+1, values)
+		}
+		ok, err := m.Exists(s.scopeOf("").eventKey("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("test case %d: expected undecodable event to be deleted", i+1)
+		}
+
+		if len(tc.DeadLetterLabels) != 0 {
+			e, err := s.Search(nil, tc.DeadLetterLabels...)
+			if err != nil {
+				t.Fatalf("test case %d: %#v", i+1, err)
+			}
+			if e.ID() == "1" || e.Payload() != "undecodable" {
+				t.Fatalf("test case %d: expected dead lettered copy of event 1, got event %s carrying %s", i+1, e.ID(), e.Payload())
+			}
+		}
+	}
+}
+
+func Test_TypedService_Subscribe_Cancel(t *testing.T) {
+	s := newTestService(t, newMemoryStorage(), nil)
+	typed := newTestTypedService(t, s, nil)
+
+	// Cancelling the context ends waiting for events of an empty queue.
+	ctx := &cancelContext{done: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- typed.Subscribe(ctx, make(chan struct{}), func(e TypedEvent[string]) error { return nil }, "a")
+	}()
+	close(ctx.done)
+	select {
+	case err := <-done:
+		if errgo.Cause(err) != errCancelled {
+			t.Fatalf("expected the error of the context, got %#v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Subscribe to return once cancelled")
+	}
+}
+
+func Test_TypedService_Subscribe_UndecodableWildcard(t *testing.T) {
+	m := newMemoryStorage()
+	s := newTestService(t, m, nil)
+	typed := newTestTypedService(t, s, func(config *TypedServiceConfig) {
+		config.SkipUndecodable = true
+	})
+
+	err := s.Create(nil, newTestEvent(t, "1", "undecodable"), "b", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = typed.Create(nil, "value", "b", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var values []string
+	err = typed.Subscribe(nil, stop, func(e TypedEvent[string]) error {
+		values = append(values, e.Value)
+		close(stop)
+		return nil
+	}, LabelWildcard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "value" {
+		t.Fatalf("expected value, got %#v", values)
+	}
+
+	// The undecodable event is deleted from the namespace it was consumed from.
+	ok, err := m.Exists(s.scopeOf("").eventKey("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected undecodable event to be deleted")
+	}
+}
@@ -0,0 +1,109 @@
+package event
+
+import (
+	"strconv"
+	"sync"
+)
+
+// NewUpcasterRegistry creates a new empty upcaster registry.
+func NewUpcasterRegistry() UpcasterRegistry {
+	newRegistry := &upcasterRegistry{
+		// Internals.
+		mutex:     sync.RWMutex{},
+		upcasters: map[string]map[int]Upcaster{},
+	}
+
+	return newRegistry
+}
+
+type upcasterRegistry struct {
+	// Internals.
+	mutex     sync.RWMutex
+	upcasters map[string]map[int]Upcaster
+}
+
+func (r *upcasterRegistry) Register(eventType string, version int, upcaster Upcaster) error {
+	if eventType == "" {
+		return maskAnyf(invalidConfigError, "event type must not be empty")
+	}
+	if version < 1 {
+		return maskAnyf(invalidConfigError, "version must be 1 or greater")
+	}
+	if upcaster == nil {
+		return maskAnyf(invalidConfigError, "upcaster must not be empty")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.upcasters[eventType] == nil {
+		r.upcasters[eventType] = map[int]Upcaster{}
+	}
+	r.upcasters[eventType][version] = upcaster
+
+	return nil
+}
+
+func (r *upcasterRegistry) Upcast(e Event) (Event, error) {
+	eventType := e.Headers()[HeaderType]
+	if eventType == "" {
+		return e, nil
+	}
+
+	version, err := typeVersion(e)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	payload := e.PayloadBytes()
+	upcasted := false
+	for {
+		upcaster, ok := r.upcasters[eventType][version]
+		if !ok {
+			break
+		}
+		payload, err = upcaster(payload)
+		if err != nil {
+			return nil, maskAnyf(invalidPayloadError, "event %s must be upcast from %s version %d: %s", e.ID(), eventType, version, err)
+		}
+		version++
+		upcasted = true
+	}
+	if !upcasted {
+		return e, nil
+	}
+
+	headers := copyHeaders(e.Headers())
+	headers[HeaderSchemaVersion] = strconv.Itoa(version)
+
+	newEvent := &event{
+		// Settings.
+		created: e.Created(),
+		headers: headers,
+		id:      e.ID(),
+		payload: payload,
+	}
+	if ev, ok := e.(*event); ok {
+		newEvent.codec = ev.codec
+	}
+
+	return newEvent, nil
+}
+
+// typeVersion returns the version of the type of the given event. Events of a
+// type not carrying a version are of version 1.
+func typeVersion(e Event) (int, error) {
+	v, ok := e.Headers()[HeaderSchemaVersion]
+	if !ok {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, maskAnyf(invalidPayloadError, "schema version of event %s must be 1 or greater", e.ID())
+	}
+
+	return version, nil
+}
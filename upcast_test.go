@@ -0,0 +1,155 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestTypedEvent creates an event of the given type and schema version.
+func newTestTypedEvent(t testing.TB, eventID, eventType, version, payload string) Event {
+	config := DefaultConfig()
+	config.Created = time.Unix(1, 0).UTC()
+	config.Headers = map[string]string{HeaderType: eventType, HeaderSchemaVersion: version}
+	config.ID = eventID
+	config.Payload = payload
+
+	e, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// appendUpcaster returns an upcaster appending the given suffix to payloads.
+func appendUpcaster(suffix string) Upcaster {
+	return func(payload []byte) ([]byte, error) {
+		return append(payload, suffix...), nil
+	}
+}
+
+func Test_UpcasterRegistry_Chain(t *testing.T) {
+	r := NewUpcasterRegistry()
+	for _, v := range []int{1, 2} {
+		err := r.Register("order", v, appendUpcaster(fmt.Sprintf("+v%d", v+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := r.Upcast(newTestTypedEvent(t, "1", "order", "1", "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "v1+v2+v3" {
+		t.Fatalf("expected payload upcast twice, got %s", e.Payload())
+	}
+	if v := e.Headers()[HeaderSchemaVersion]; v != "3" {
+		t.Fatalf("expected schema version 3, got %s", v)
+	}
+	if e.ID() != "1" || !e.Created().Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected upcast event to keep its ID and creation time, got %s created at %s", e.ID(), e.Created())
+	}
+
+	// Events of the latest version and of other types are returned as they are.
+	for _, in := range []Event{
+		newTestTypedEvent(t, "2", "order", "3", "v3"),
+		newTestTypedEvent(t, "3", "invoice", "1", "v1"),
+	} {
+		out, err := r.Upcast(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != in {
+			t.Fatalf("expected event %s not to be upcast", in.ID())
+		}
+	}
+}
+
+func Test_UpcasterRegistry_MissingStep(t *testing.T) {
+	r := NewUpcasterRegistry()
+	err := r.Register("order", 1, appendUpcaster("+v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register("order", 3, appendUpcaster("+v4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an upcaster for version 2 the chain stops there.
+	e, err := r.Upcast(newTestTypedEvent(t, "1", "order", "1", "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Payload() != "v1+v2" || e.Headers()[HeaderSchemaVersion] != "2" {
+		t.Fatalf("expected payload v1+v2 of version 2, got %s of version %s", e.Payload(), e.Headers()[HeaderSchemaVersion])
+	}
+}
+
+func Test_UpcasterRegistry_Error(t *testing.T) {
+	r := NewUpcasterRegistry()
+	err := r.Register("order", 1, func(payload []byte) ([]byte, error) {
+		return nil, fmt.Errorf("broken")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Upcast(newTestTypedEvent(t, "1", "order", "1", "v1"))
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error, got %#v", err)
+	}
+
+	_, err = r.Upcast(newTestTypedEvent(t, "2", "order", "0", "v0"))
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error for version 0, got %#v", err)
+	}
+}
+
+func Test_Service_Search_UpcasterError(t *testing.T) {
+	r := NewUpcasterRegistry()
+	err := r.Register("order", 1, func(payload []byte) ([]byte, error) {
+		return nil, fmt.Errorf("broken")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, newMemoryStorage(), func(config *ServiceConfig) {
+		config.UpcasterRegistry = r
+	})
+
+	for _, eventID := range []string{"1", "2", "3"} {
+		err := s.Create(nil, newTestTypedEvent(t, eventID, "order", "1", "v1"), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = s.Search(nil, "a")
+	if !IsInvalidPayload(err) {
+		t.Fatalf("expected invalid payload error, got %#v", err)
+	}
+
+	// The failing event is quarantined instead of being lost, and the failure
+	// does not drain the other events of the namespace.
+	quarantined, err := s.Quarantined(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0] != "1" {
+		t.Fatalf("expected event 1 to be quarantined, got %#v", quarantined)
+	}
+	events, err := s.SearchAll(nil, "a")
+	if err == nil {
+		t.Fatalf("expected SearchAll to fail upcasting, got %d events", len(events))
+	}
+	eventIDs, err := s.shardFor(s.scopeOf(""), s.namespaceFromLabels("a")).GetAllFromList(s.scopeOf("").namespaceKey(s.namespaceFromLabels("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eventIDs) != 2 {
+		t.Fatalf("expected the namespace to still hold 2 events, got %#v", eventIDs)
+	}
+}